@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"unsafe"
+)
+
+// A binary graph holds a RoadGraph's arrays as they sit in memory, so
+// loading one is a memory map and a checksum rather than a JSON decode.
+// Everything is little-endian; each section starts on an 8-byte boundary.
+//
+//	header (32 bytes)
+//	  magic      [8]byte "RSGRAPH\x00"
+//	  version    uint32
+//	  nodes      uint32
+//	  edges      uint32
+//	  checksum   uint32  CRC-32C of everything after the header
+//	  turns      uint32
+//	  reserved   [4]byte
+//	NodeID     [nodes]int64, ascending
+//	Lat, Lon   [nodes]float32 each
+//	EdgeStart  [nodes+1]int32
+//	EdgeTo     [edges]int32
+//	EdgeDist   [edges]float32, meters
+//	EdgeSpeed  [edges]float32, km/h
+//	Flags      [nodes]uint8: 1 traffic light, 2 stop sign
+//	TurnIn, TurnOut  [turns]int32 each, sorted
+//	TurnKind         [turns]uint8: 1 no, 2 only, 3 penalty
+//	TurnSeconds      [turns]float32
+const (
+	binaryGraphMagic   = "RSGRAPH\x00"
+	binaryGraphVersion = 2
+	binaryGraphHeader  = 32
+
+	nodeTrafficLight = 1 << 0
+	nodeStopSign     = 1 << 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// isBinaryGraph reports whether data starts like a binary graph.
+func isBinaryGraph(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryGraphMagic))
+}
+
+// writeBinaryGraph saves g to path in the binary format.
+func writeBinaryGraph(path string, g *RoadGraph) error {
+	n, m, r := g.NumNodes(), len(g.EdgeTo), len(g.TurnIn)
+	var body bytes.Buffer
+	section := func(v any) {
+		binary.Write(&body, binary.LittleEndian, v)
+		body.Write(make([]byte, (8-body.Len()%8)%8))
+	}
+
+	ids := make([]int64, n)
+	lats, lons := make([]float32, n), make([]float32, n)
+	flags := make([]uint8, n)
+	for i := 0; i < n; i++ {
+		ids[i] = int64(g.NodeID[i])
+		lats[i], lons[i] = float32(g.Lat.At(int32(i))), float32(g.Lon.At(int32(i)))
+		if g.TrafficLight[i] {
+			flags[i] |= nodeTrafficLight
+		}
+		if g.StopSign[i] {
+			flags[i] |= nodeStopSign
+		}
+	}
+	dists, speeds := make([]float32, m), make([]float32, m)
+	for e := 0; e < m; e++ {
+		dists[e], speeds[e] = float32(g.EdgeDist.At(int32(e))), float32(g.EdgeSpeed.At(int32(e)))
+	}
+	section(ids)
+	section(lats)
+	section(lons)
+	section(g.EdgeStart)
+	section(g.EdgeTo)
+	section(dists)
+	section(speeds)
+	section(flags)
+	turnSeconds := make([]float32, r)
+	for k, s := range g.TurnSeconds {
+		turnSeconds[k] = float32(s)
+	}
+	section(g.TurnIn)
+	section(g.TurnOut)
+	section(g.TurnKind)
+	section(turnSeconds)
+
+	header := make([]byte, binaryGraphHeader)
+	copy(header, binaryGraphMagic)
+	binary.LittleEndian.PutUint32(header[8:], binaryGraphVersion)
+	binary.LittleEndian.PutUint32(header[12:], uint32(n))
+	binary.LittleEndian.PutUint32(header[16:], uint32(m))
+	binary.LittleEndian.PutUint32(header[20:], crc32.Checksum(body.Bytes(), castagnoli))
+	binary.LittleEndian.PutUint32(header[24:], uint32(r))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating graph: %w", err)
+	}
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return fmt.Errorf("writing graph: %w", err)
+	}
+	if _, err := body.WriteTo(file); err != nil {
+		file.Close()
+		return fmt.Errorf("writing graph: %w", err)
+	}
+	return file.Close()
+}
+
+// readBinaryGraph builds a RoadGraph over data, a whole binary graph file.
+// Node IDs, coordinates, edges and turn rules point straight into data,
+// which must stay mapped and unchanged for as long as the graph is in use.
+func readBinaryGraph(data []byte) (*RoadGraph, error) {
+	if len(data) < binaryGraphHeader || !isBinaryGraph(data) {
+		return nil, errors.New("not a binary graph")
+	}
+	if v := binary.LittleEndian.Uint32(data[8:]); v != binaryGraphVersion {
+		return nil, fmt.Errorf("format version %d, this build reads version %d; convert the JSON graph again with `ridesync convert-graph`", v, binaryGraphVersion)
+	}
+	n := int(binary.LittleEndian.Uint32(data[12:]))
+	m := int(binary.LittleEndian.Uint32(data[16:]))
+	r := int(binary.LittleEndian.Uint32(data[24:]))
+	body := data[binaryGraphHeader:]
+	if sum := crc32.Checksum(body, castagnoli); sum != binary.LittleEndian.Uint32(data[20:]) {
+		return nil, errors.New("checksum mismatch; the file is corrupt or truncated")
+	}
+
+	off := 0
+	section := func(count, size int) ([]byte, error) {
+		length := count * size
+		if off+length > len(body) {
+			return nil, errors.New("file is shorter than its header says")
+		}
+		s := body[off : off+length]
+		off += length + (8-length%8)%8
+		return s, nil
+	}
+	var sections [12][]byte
+	for i, s := range []struct{ count, size int }{{n, 8}, {n, 4}, {n, 4}, {n + 1, 4}, {m, 4}, {m, 4}, {m, 4}, {n, 1}, {r, 4}, {r, 4}, {r, 1}, {r, 4}} {
+		var err error
+		if sections[i], err = section(s.count, s.size); err != nil {
+			return nil, err
+		}
+	}
+
+	g := &RoadGraph{
+		NodeID:       ints(sections[0]),
+		Lat:          floatColumn{narrow: float32s(sections[1])},
+		Lon:          floatColumn{narrow: float32s(sections[2])},
+		TrafficLight: make([]bool, n),
+		StopSign:     make([]bool, n),
+		EdgeStart:    int32s(sections[3]),
+		EdgeTo:       int32s(sections[4]),
+		EdgeDist:     floatColumn{narrow: float32s(sections[5])},
+		EdgeSpeed:    floatColumn{narrow: float32s(sections[6])},
+		TurnIn:       int32s(sections[8]),
+		TurnOut:      int32s(sections[9]),
+		TurnKind:     sections[10],
+		TurnSeconds:  make([]float64, r),
+	}
+	for i := 0; i < n; i++ {
+		g.TrafficLight[i] = sections[7][i]&nodeTrafficLight != 0
+		g.StopSign[i] = sections[7][i]&nodeStopSign != 0
+		if i > 0 && g.NodeID[i] <= g.NodeID[i-1] {
+			return nil, fmt.Errorf("node IDs are not in ascending order at node %d", i)
+		}
+	}
+	for k := 0; k < r; k++ {
+		g.TurnSeconds[k] = float64(math.Float32frombits(binary.LittleEndian.Uint32(sections[11][k*4:])))
+	}
+
+	// The checksum only proves the file is what was written; make sure no
+	// edge can index out of range either
+	if g.EdgeStart[0] != 0 || int(g.EdgeStart[n]) != m {
+		return nil, errors.New("edge offsets don't cover the edge list")
+	}
+	for i := 0; i < n; i++ {
+		if g.EdgeStart[i] > g.EdgeStart[i+1] {
+			return nil, fmt.Errorf("edge offsets go backwards at node %d", i)
+		}
+	}
+	for e, to := range g.EdgeTo {
+		if to < 0 || int(to) >= n {
+			return nil, fmt.Errorf("edge %d leads to node %d, past the last node", e, to)
+		}
+	}
+	for k := 0; k < r; k++ {
+		in, out := g.TurnIn[k], g.TurnOut[k]
+		switch {
+		case in < 0 || int(in) >= m || out < 0 || int(out) >= m:
+			return nil, fmt.Errorf("turn rule %d names an edge past the last one", k)
+		case g.edgeFrom(out) != g.EdgeTo[in]:
+			return nil, fmt.Errorf("turn rule %d joins edges that don't meet", k)
+		case k > 0 && in < g.TurnIn[k-1]:
+			return nil, fmt.Errorf("turn rules are not sorted at rule %d", k)
+		case g.TurnKind[k] < turnBanned || g.TurnKind[k] > turnPenalty:
+			return nil, fmt.Errorf("turn rule %d is of unknown kind %d", k, g.TurnKind[k])
+		}
+	}
+	g.prepare()
+	return g, nil
+}
+
+// int32s views b as little-endian int32s without copying when the machine
+// is little-endian too, and copies otherwise.
+func int32s(b []byte) []int32 {
+	if len(b) == 0 {
+		return []int32{}
+	}
+	if littleEndian && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*int32)(unsafe.Pointer(&b[0])), len(b)/4)
+	}
+	out := make([]int32, len(b)/4)
+	for i := range out {
+		out[i] = int32(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return out
+}
+
+// float32s is int32s for float32s.
+func float32s(b []byte) []float32 {
+	if len(b) == 0 {
+		return []float32{}
+	}
+	if littleEndian && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*float32)(unsafe.Pointer(&b[0])), len(b)/4)
+	}
+	out := make([]float32, len(b)/4)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return out
+}
+
+// ints views b as little-endian int64s, as ints, without copying where int
+// is 64 bits and the machine little-endian, and copies otherwise.
+func ints(b []byte) []int {
+	if len(b) == 0 {
+		return []int{}
+	}
+	if littleEndian && unsafe.Sizeof(int(0)) == 8 && uintptr(unsafe.Pointer(&b[0]))%8 == 0 {
+		return unsafe.Slice((*int)(unsafe.Pointer(&b[0])), len(b)/8)
+	}
+	out := make([]int, len(b)/8)
+	for i := range out {
+		out[i] = int(int64(binary.LittleEndian.Uint64(b[i*8:])))
+	}
+	return out
+}
+
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// runConvertGraphCommand implements `ridesync convert-graph`: it turns a
+// graph.json into the binary format.
+func runConvertGraphCommand(args []string) error {
+	fs := flag.NewFlagSet("convert-graph", flag.ExitOnError)
+	in := fs.String("in", "graph/graph.json", "JSON graph to read")
+	out := fs.String("out", "graph/graph.bin", "binary graph to write")
+	fs.Parse(args)
+
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var nodes map[string]GraphNode
+	if err := json.NewDecoder(file).Decode(&nodes); err != nil {
+		return fmt.Errorf("reading %s: %w", *in, err)
+	}
+	g := buildRoadGraph(nodes)
+	if err := writeBinaryGraph(*out, g); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Wrote %d nodes, %d edges and %d turn rules to %s\n", g.NumNodes(), len(g.EdgeTo), len(g.TurnIn), *out)
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestGraph saves g in the binary format and returns the path.
+func writeTestGraph(t *testing.T, g *RoadGraph) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "graph.bin")
+	if err := writeBinaryGraph(path, g); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// mapTestGraph maps the file at path the way loadGraph does.
+func mapTestGraph(t *testing.T, path string) []byte {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	data, err := mapFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBinaryGraphRoundTrip(t *testing.T) {
+	nodes := testGridNodes(5, 6)
+	stop := nodes["8"]
+	stop.StopSign = true
+	nodes["8"] = stop
+	via := nodes["9"]
+	via.Turns = []TurnRule{{From: "8", To: "15", Kind: "no"}, {From: "10", To: "3", Kind: "penalty", Seconds: 12}}
+	nodes["9"] = via
+	want := buildRoadGraph(nodes)
+	if len(want.TurnIn) != 2 {
+		t.Fatalf("test graph has %d turn rules, want 2", len(want.TurnIn))
+	}
+
+	got, err := readBinaryGraph(mapTestGraph(t, writeTestGraph(t, want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NumNodes() != want.NumNodes() || len(got.EdgeTo) != len(want.EdgeTo) || len(got.TurnIn) != len(want.TurnIn) {
+		t.Fatalf("read %d nodes, %d edges, %d turns; wrote %d, %d, %d", got.NumNodes(), len(got.EdgeTo), len(got.TurnIn),
+			want.NumNodes(), len(want.EdgeTo), len(want.TurnIn))
+	}
+	for i := int32(0); i < int32(want.NumNodes()); i++ {
+		if got.NodeID[i] != want.NodeID[i] || got.TrafficLight[i] != want.TrafficLight[i] || got.StopSign[i] != want.StopSign[i] {
+			t.Errorf("node %d: read %+v, wrote %+v", i, got.Node(i), want.Node(i))
+		}
+		if got.Lat.At(i) != float64(float32(want.Lat.At(i))) || got.Lon.At(i) != float64(float32(want.Lon.At(i))) {
+			t.Errorf("node %d: read (%v, %v), wrote (%v, %v)", i, got.Lat.At(i), got.Lon.At(i), want.Lat.At(i), want.Lon.At(i))
+		}
+		if got.EdgeStart[i] != want.EdgeStart[i] {
+			t.Errorf("node %d: edges start at %d, wrote %d", i, got.EdgeStart[i], want.EdgeStart[i])
+		}
+	}
+	for e := int32(0); e < int32(len(want.EdgeTo)); e++ {
+		if got.EdgeTo[e] != want.EdgeTo[e] || got.EdgeDist.At(e) != float64(float32(want.EdgeDist.At(e))) ||
+			got.EdgeSpeed.At(e) != float64(float32(want.EdgeSpeed.At(e))) {
+			t.Errorf("edge %d: read to %d, %v m at %v km/h; wrote to %d, %v m at %v km/h", e,
+				got.EdgeTo[e], got.EdgeDist.At(e), got.EdgeSpeed.At(e), want.EdgeTo[e], want.EdgeDist.At(e), want.EdgeSpeed.At(e))
+		}
+	}
+	for k := range want.TurnIn {
+		if got.TurnIn[k] != want.TurnIn[k] || got.TurnOut[k] != want.TurnOut[k] || got.TurnKind[k] != want.TurnKind[k] ||
+			got.TurnSeconds[k] != want.TurnSeconds[k] {
+			t.Errorf("turn rule %d differs", k)
+		}
+	}
+	if path := aStarIndexed(got, routeCost, 0, int32(got.NumNodes()-1)); path == nil {
+		t.Error("no route on the graph read back")
+	}
+}
+
+func TestBinaryGraphRepairsMappedDistance(t *testing.T) {
+	g := testGrid(3, 3)
+	g.EdgeDist.Set(2, -1)
+	// The mapping is read-only; repairing the distance must not write to it
+	got, err := readBinaryGraph(mapTestGraph(t, writeTestGraph(t, g)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Report.BadDistances != 1 || !(got.EdgeDist.At(2) > 0) {
+		t.Errorf("bad distance not repaired: %d repairs, distance %v", got.Report.BadDistances, got.EdgeDist.At(2))
+	}
+}
+
+func TestBinaryGraphRejectsDamage(t *testing.T) {
+	path := writeTestGraph(t, testGrid(4, 4))
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+		want   string
+	}{
+		{"flipped byte", func(data []byte) []byte {
+			data[binaryGraphHeader+100] ^= 0x40
+			return data
+		}, "checksum mismatch"},
+		{"truncated", func(data []byte) []byte {
+			return data[:len(data)-16]
+		}, "checksum mismatch"},
+		{"other version", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[8:], binaryGraphVersion+1)
+			return data
+		}, "format version"},
+		{"not a graph", func(data []byte) []byte {
+			return []byte(`{"1": {"id": 1}}`)
+		}, "not a binary graph"},
+	}
+	for _, tt := range tests {
+		data := tt.damage(append([]byte(nil), good...))
+		_, err := readBinaryGraph(data)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one saying %q", tt.name, err, tt.want)
+		}
+	}
+}
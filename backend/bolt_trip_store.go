@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var tripsBucket = []byte("trips")
+
+// BoltTripStore keeps trips in a BoltDB file. Keys are the drop-off time
+// followed by the trip ID, both big-endian, so a time range is one cursor
+// scan.
+type BoltTripStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltTripStore(path string) (*BoltTripStore, error) {
+	// Fail instead of hanging if another process holds the file lock
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tripsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltTripStore{db: db}, nil
+}
+
+func tripKey(droppedOff time.Time, id uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(droppedOff.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], id)
+	return key
+}
+
+func (s *BoltTripStore) Save(trip Trip) (Trip, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tripsBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		trip.ID = id
+		data, err := json.Marshal(trip)
+		if err != nil {
+			return err
+		}
+		return b.Put(tripKey(trip.DroppedOffAt, id), data)
+	})
+	return trip, err
+}
+
+func (s *BoltTripStore) Query(q TripQuery) ([]Trip, error) {
+	out := []Trip{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(tripsBucket).Cursor()
+		k, v := c.First()
+		if !q.From.IsZero() {
+			k, v = c.Seek(tripKey(q.From, 0))
+		}
+		for ; k != nil; k, v = c.Next() {
+			var trip Trip
+			if err := json.Unmarshal(v, &trip); err != nil {
+				return err
+			}
+			if !q.To.IsZero() && !trip.DroppedOffAt.Before(q.To) {
+				break
+			}
+			if !q.matches(trip) {
+				continue
+			}
+			out = append(out, trip)
+			if q.Limit > 0 && len(out) == q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *BoltTripStore) Close() error {
+	return s.db.Close()
+}
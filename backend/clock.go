@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the simulation's source of time. moveDrivers only ever asks the
+// clock, so a run can follow the wall clock, a multiple of it, or skip
+// straight from one scheduled move to the next.
+type Clock interface {
+	Now() time.Time
+	// SleepUntil blocks until the clock reads at least t and returns the time
+	// it woke at.
+	SleepUntil(t time.Time) time.Time
+	// EventDriven clocks jump to the next scheduled event instead of
+	// waking up every tick, so one loop has to run the whole simulation
+	// (runEvents) for everything to happen on their time.
+	EventDriven() bool
+}
+
+// RealClock is wall-clock time.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+func (RealClock) SleepUntil(t time.Time) time.Time {
+	time.Sleep(time.Until(t))
+	return time.Now()
+}
+
+func (RealClock) EventDriven() bool { return false }
+
+// ScaledClock runs Factor times faster than the wall clock, starting from
+// start when it is created.
+type ScaledClock struct {
+	Factor    float64
+	start     time.Time
+	wallStart time.Time
+}
+
+func NewScaledClock(factor float64) *ScaledClock {
+	return NewScaledClockAt(factor, time.Now())
+}
+
+func NewScaledClockAt(factor float64, start time.Time) *ScaledClock {
+	return &ScaledClock{Factor: factor, start: start, wallStart: time.Now()}
+}
+
+func (c *ScaledClock) Now() time.Time {
+	elapsed := time.Since(c.wallStart)
+	return c.start.Add(time.Duration(float64(elapsed) * c.Factor))
+}
+
+func (c *ScaledClock) SleepUntil(t time.Time) time.Time {
+	time.Sleep(time.Duration(float64(t.Sub(c.Now())) / c.Factor))
+	return c.Now()
+}
+
+func (c *ScaledClock) EventDriven() bool { return false }
+
+// EventClock is a discrete-event clock: time only moves when the driver loop
+// sleeps, and sleeping never waits.
+type EventClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewEventClock(start time.Time) *EventClock {
+	return &EventClock{now: start}
+}
+
+func (c *EventClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *EventClock) SleepUntil(t time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	return c.now
+}
+
+func (c *EventClock) EventDriven() bool { return true }
+
+// newClock picks a clock for a speed setting: 1 is real time, above 1 is
+// that many times faster, and 0 runs event to event as fast as possible.
+func newClock(speed float64) Clock {
+	return resumeClock(speed, time.Now())
+}
+
+// resumeClock is newClock for a simulation that had reached at. Real time
+// can't be rewound, so at only matters for the other speeds.
+func resumeClock(speed float64, at time.Time) Clock {
+	switch {
+	case speed == 0:
+		return NewEventClock(at)
+	case speed == 1:
+		return RealClock{}
+	default:
+		return NewScaledClockAt(speed, at)
+	}
+}
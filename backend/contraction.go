@@ -0,0 +1,563 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sync"
+)
+
+// A ContractionHierarchy answers the router's queries without searching the
+// whole city. It is built over the same states as the search in
+// path_find.go, the edge a vehicle has just driven along, so it honours turn
+// rules and turn costs. Building removes states one by one in order of
+// importance, adding a shortcut wherever removing one would lengthen a
+// shortest route; a query then only ever climbs towards more important
+// states, from both ends at once, and meets in the middle.
+type ContractionHierarchy struct {
+	Model CostModel // the cost model the shortcuts were built with
+
+	// Arcs from each state to more important ones, in the direction of travel
+	UpStart []int32
+	UpTo    []int32
+	UpCost  []float64
+	UpMid   []int32 // the state a shortcut skips, -1 for a plain turn
+
+	// Arcs into each state from more important ones
+	DownStart []int32
+	DownFrom  []int32
+	DownCost  []float64
+	DownMid   []int32
+
+	// Derived on load: the edges arriving at each node, for query targets,
+	// and how many meters of road each arc stands for
+	inStart    []int32
+	inEdge     []int32
+	upMeters   []float64
+	downMeters []float64
+}
+
+// chEnd is a state a query starts or stops at, and the cost of getting from
+// the start to it or from it to the finish.
+type chEnd struct {
+	state int32
+	cost  float64
+}
+
+// turnArcs calls visit with every turn a vehicle may make: arriving over
+// edge in and leaving over out, at the cost of the turn and of driving out.
+func turnArcs(g *RoadGraph, model CostModel, visit func(in, out int32, cost float64)) {
+	for in := int32(0); in < int32(len(g.EdgeTo)); in++ {
+		lo, hi := g.Edges(g.EdgeTo[in])
+		for out := lo; out < hi; out++ {
+			if seconds, ok := g.Turn(in, out); ok {
+				visit(in, out, model.TurnCost(g, seconds)+model.EdgeCost(g, out))
+			}
+		}
+	}
+}
+
+// chArc is an arc between states while the hierarchy is being built.
+type chArc struct {
+	to   int32 // or from, in an in list
+	cost float64
+	mid  int32
+}
+
+type chBuilder struct {
+	out, in  [][]chArc // arcs between states not yet contracted
+	up, down [][]chArc
+	deleted  []int // contracted neighbors, which spreads contraction out
+	depth    []int // how many levels of shortcuts lie below, kept shallow
+	witness  witnessSearch
+}
+
+// Witness searches give up after settling this many states, and a shortcut
+// is added in case. That costs a few extra shortcuts, never a wrong answer.
+const (
+	witnessLimitDry = 100
+	witnessLimit    = 500
+)
+
+// buildContraction contracts every state of g's turn graph under model.
+func buildContraction(g *RoadGraph, model CostModel) *ContractionHierarchy {
+	n := len(g.EdgeTo)
+	b := &chBuilder{
+		out:     make([][]chArc, n),
+		in:      make([][]chArc, n),
+		up:      make([][]chArc, n),
+		down:    make([][]chArc, n),
+		deleted: make([]int, n),
+		depth:   make([]int, n),
+		witness: newWitnessSearch(n),
+	}
+	turnArcs(g, model, func(in, out int32, cost float64) {
+		b.addArc(in, out, cost, -1)
+	})
+
+	// Priorities are updated lazily: contracting a state only marks its
+	// neighbors stale, and a stale state is priced again when it reaches the
+	// front of the queue. If it no longer beats the next one it goes back in
+	queue := make(chQueue, n)
+	for v := range queue {
+		queue[v] = chQueueItem{int32(v), b.priority(int32(v))}
+	}
+	heap.Init(&queue)
+	stale := make([]bool, n)
+	touched := make([]bool, n)
+	for queue.Len() > 0 {
+		item := heap.Pop(&queue).(chQueueItem)
+		v := item.state
+		if stale[v] {
+			stale[v] = false
+			if p := b.priority(v); queue.Len() > 0 && p > queue[0].priority {
+				heap.Push(&queue, chQueueItem{v, p})
+				continue
+			}
+		}
+
+		b.contract(v, false)
+		b.up[v], b.down[v] = b.out[v], b.in[v]
+		var neighbors []int32
+		for _, a := range b.out[v] {
+			b.in[a.to] = removeArc(b.in[a.to], v)
+			neighbors = append(neighbors, a.to)
+		}
+		for _, a := range b.in[v] {
+			b.out[a.to] = removeArc(b.out[a.to], v)
+			neighbors = append(neighbors, a.to)
+		}
+		b.out[v], b.in[v] = nil, nil
+		for _, u := range neighbors {
+			if !touched[u] {
+				touched[u] = true
+				b.deleted[u]++
+				if b.depth[v]+1 > b.depth[u] {
+					b.depth[u] = b.depth[v] + 1
+				}
+			}
+		}
+		for _, u := range neighbors {
+			touched[u] = false
+			stale[u] = true
+		}
+	}
+	return newContractionHierarchy(g, model, b.up, b.down)
+}
+
+// addArc adds u -> x, or lowers the cost of the one already there.
+func (b *chBuilder) addArc(u, x int32, cost float64, mid int32) {
+	for i, a := range b.out[u] {
+		if a.to != x {
+			continue
+		}
+		if cost < a.cost {
+			b.out[u][i] = chArc{x, cost, mid}
+			for j, r := range b.in[x] {
+				if r.to == u {
+					b.in[x][j] = chArc{u, cost, mid}
+				}
+			}
+		}
+		return
+	}
+	b.out[u] = append(b.out[u], chArc{x, cost, mid})
+	b.in[x] = append(b.in[x], chArc{u, cost, mid})
+}
+
+func removeArc(arcs []chArc, to int32) []chArc {
+	for i, a := range arcs {
+		if a.to == to {
+			return append(arcs[:i], arcs[i+1:]...)
+		}
+	}
+	return arcs
+}
+
+// contract adds the shortcuts needed so that removing v leaves every
+// shortest route between the rest as short, and returns how many. With dry
+// set it only counts them.
+func (b *chBuilder) contract(v int32, dry bool) int {
+	limit := witnessLimit
+	if dry {
+		limit = witnessLimitDry
+	}
+	longest := 0.0
+	for _, a := range b.out[v] {
+		longest = math.Max(longest, a.cost)
+	}
+	shortcuts := 0
+	for _, in := range b.in[v] {
+		u := in.to
+		b.witness.run(b.out, u, v, in.cost+longest, limit)
+		for _, out := range b.out[v] {
+			x := out.to
+			cost := in.cost + out.cost
+			if x == u || b.witness.within(x, cost) {
+				continue
+			}
+			shortcuts++
+			if !dry {
+				b.addArc(u, x, cost, v)
+			}
+		}
+	}
+	return shortcuts
+}
+
+// priority orders contraction: states whose removal adds the fewest arcs
+// go first, and neighbors of contracted states are held back so the
+// hierarchy stays even.
+func (b *chBuilder) priority(v int32) int {
+	return 2*(b.contract(v, true)-len(b.in[v])-len(b.out[v])) + b.deleted[v] + b.depth[v]
+}
+
+// witnessSearch looks for a route around a state being contracted.
+type witnessSearch struct {
+	stamp uint32
+	seen  []uint32
+	dist  []float64
+	open  openList
+}
+
+func newWitnessSearch(n int) witnessSearch {
+	return witnessSearch{seen: make([]uint32, n), dist: make([]float64, n)}
+}
+
+// run finds costs from source without passing skip, up to limit cost or
+// maxSettled settled states.
+func (w *witnessSearch) run(out [][]chArc, source, skip int32, limit float64, maxSettled int) {
+	w.stamp++
+	w.open = w.open[:0]
+	w.seen[source], w.dist[source] = w.stamp, 0
+	heap.Push(&w.open, openItem{edge: source})
+	for settled := 0; w.open.Len() > 0 && settled < maxSettled; settled++ {
+		item := heap.Pop(&w.open).(openItem)
+		if item.f > w.dist[item.edge] {
+			continue
+		}
+		if item.f > limit {
+			return
+		}
+		for _, a := range out[item.edge] {
+			if a.to == skip {
+				continue
+			}
+			d := item.f + a.cost
+			if w.seen[a.to] != w.stamp || d < w.dist[a.to] {
+				w.seen[a.to], w.dist[a.to] = w.stamp, d
+				heap.Push(&w.open, openItem{edge: a.to, f: d})
+			}
+		}
+	}
+}
+
+// within reports whether the last search reached x for at most cost.
+func (w *witnessSearch) within(x int32, cost float64) bool {
+	return w.seen[x] == w.stamp && w.dist[x] <= cost
+}
+
+type chQueueItem struct {
+	state    int32
+	priority int
+}
+
+type chQueue []chQueueItem
+
+func (q chQueue) Len() int { return len(q) }
+func (q chQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].state < q[j].state
+}
+func (q chQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *chQueue) Push(x interface{}) { *q = append(*q, x.(chQueueItem)) }
+func (q *chQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// newContractionHierarchy lays the up and down arcs out flat.
+func newContractionHierarchy(g *RoadGraph, model CostModel, up, down [][]chArc) *ContractionHierarchy {
+	ch := &ContractionHierarchy{Model: model}
+	flatten := func(arcs [][]chArc) (start, to []int32, cost []float64, mid []int32) {
+		start = make([]int32, len(arcs)+1)
+		for v, list := range arcs {
+			start[v] = int32(len(to))
+			for _, a := range list {
+				to = append(to, a.to)
+				cost = append(cost, a.cost)
+				mid = append(mid, a.mid)
+			}
+		}
+		start[len(arcs)] = int32(len(to))
+		return
+	}
+	ch.UpStart, ch.UpTo, ch.UpCost, ch.UpMid = flatten(up)
+	ch.DownStart, ch.DownFrom, ch.DownCost, ch.DownMid = flatten(down)
+	ch.prepare(g)
+	return ch
+}
+
+// prepare indexes the edges arriving at each node and measures the arcs.
+func (ch *ContractionHierarchy) prepare(g *RoadGraph) {
+	n := g.NumNodes()
+	ch.inStart = make([]int32, n+1)
+	for _, to := range g.EdgeTo {
+		ch.inStart[to+1]++
+	}
+	for i := 0; i < n; i++ {
+		ch.inStart[i+1] += ch.inStart[i]
+	}
+	ch.inEdge = make([]int32, len(g.EdgeTo))
+	next := append([]int32(nil), ch.inStart[:n]...)
+	for e, to := range g.EdgeTo {
+		ch.inEdge[next[to]] = int32(e)
+		next[to]++
+	}
+
+	// A plain turn covers the edge it turns onto; a shortcut, the two arcs
+	// either side of the state it skips. Each is measured once, when first
+	// asked for
+	ch.upMeters = make([]float64, len(ch.UpTo))
+	ch.downMeters = make([]float64, len(ch.DownFrom))
+	for i := range ch.upMeters {
+		ch.upMeters[i] = -1
+	}
+	for i := range ch.downMeters {
+		ch.downMeters[i] = -1
+	}
+	var up func(u, a int32) float64
+	var down func(x, a int32) float64
+	up = func(u, a int32) float64 {
+		if ch.upMeters[a] < 0 {
+			x, mid := ch.UpTo[a], ch.UpMid[a]
+			ch.upMeters[a] = g.EdgeDist.At(x)
+			if mid >= 0 {
+				ch.upMeters[a] = down(mid, ch.downArc(u, mid)) + up(mid, ch.upArc(mid, x))
+			}
+		}
+		return ch.upMeters[a]
+	}
+	down = func(x, a int32) float64 {
+		if ch.downMeters[a] < 0 {
+			u, mid := ch.DownFrom[a], ch.DownMid[a]
+			ch.downMeters[a] = g.EdgeDist.At(x)
+			if mid >= 0 {
+				ch.downMeters[a] = down(mid, ch.downArc(u, mid)) + up(mid, ch.upArc(mid, x))
+			}
+		}
+		return ch.downMeters[a]
+	}
+	for u := int32(0); u < int32(len(ch.UpStart)-1); u++ {
+		for a := ch.UpStart[u]; a < ch.UpStart[u+1]; a++ {
+			up(u, a)
+		}
+		for a := ch.DownStart[u]; a < ch.DownStart[u+1]; a++ {
+			down(u, a)
+		}
+	}
+}
+
+// upArc finds the arc u -> x stored going up out of u.
+func (ch *ContractionHierarchy) upArc(u, x int32) int32 {
+	for a := ch.UpStart[u]; a < ch.UpStart[u+1]; a++ {
+		if ch.UpTo[a] == x {
+			return a
+		}
+	}
+	return -1
+}
+
+// downArc finds the arc u -> x stored going down into x.
+func (ch *ContractionHierarchy) downArc(u, x int32) int32 {
+	for a := ch.DownStart[x]; a < ch.DownStart[x+1]; a++ {
+		if ch.DownFrom[a] == u {
+			return a
+		}
+	}
+	return -1
+}
+
+// chSide is one direction of a query.
+type chSide struct {
+	seen   []uint32
+	dist   []float64
+	parent []int32 // the state before, in the direction of the search
+	mid    []int32 // what the arc from parent skips
+	open   openList
+}
+
+type chSearch struct {
+	stamp         uint32
+	forward, back chSide
+}
+
+var chSearchPool sync.Pool
+
+func acquireCHSearch(n int) *chSearch {
+	s, _ := chSearchPool.Get().(*chSearch)
+	if s == nil || len(s.forward.seen) != n {
+		side := func() chSide {
+			return chSide{
+				seen:   make([]uint32, n),
+				dist:   make([]float64, n),
+				parent: make([]int32, n),
+				mid:    make([]int32, n),
+			}
+		}
+		s = &chSearch{forward: side(), back: side()}
+	}
+	s.stamp++
+	if s.stamp == 0 {
+		for i := range s.forward.seen {
+			s.forward.seen[i], s.back.seen[i] = 0, 0
+		}
+		s.stamp = 1
+	}
+	s.forward.open = s.forward.open[:0]
+	s.back.open = s.back.open[:0]
+	return s
+}
+
+func (side *chSide) reach(stamp uint32, v, parent, mid int32, d float64) {
+	if side.seen[v] == stamp && d >= side.dist[v] {
+		return
+	}
+	side.seen[v], side.dist[v] = stamp, d
+	side.parent[v], side.mid[v] = parent, mid
+	heap.Push(&side.open, openItem{edge: v, f: d})
+}
+
+// route finds the cheapest way from any source to any target and returns
+// its states, first to last, and its cost; nil if there is none.
+func (ch *ContractionHierarchy) route(sources, targets []chEnd) ([]int32, float64) {
+	s := acquireCHSearch(len(ch.UpStart) - 1)
+	defer chSearchPool.Put(s)
+	for _, e := range sources {
+		s.forward.reach(s.stamp, e.state, -1, -1, e.cost)
+	}
+	for _, e := range targets {
+		s.back.reach(s.stamp, e.state, -1, -1, e.cost)
+	}
+
+	best, meet := math.Inf(1), int32(-1)
+	// settle pops one state off side's open list and climbs from it; it
+	// reports false once that side can't improve on best. A state some
+	// higher state reaches more cheaply over an arc coming down is stalled:
+	// no cheapest route climbs on from it
+	settle := func(side, other *chSide, start, to []int32, cost []float64, mid []int32, stallStart, stallTo []int32, stallCost []float64) bool {
+		for side.open.Len() > 0 {
+			item := heap.Pop(&side.open).(openItem)
+			v := item.edge
+			if item.f > side.dist[v] {
+				continue
+			}
+			if item.f >= best {
+				side.open = side.open[:0]
+				return false
+			}
+			if other.seen[v] == s.stamp && item.f+other.dist[v] < best {
+				best, meet = item.f+other.dist[v], v
+			}
+			for a := stallStart[v]; a < stallStart[v+1]; a++ {
+				if w := stallTo[a]; side.seen[w] == s.stamp && side.dist[w]+stallCost[a] < item.f {
+					return true
+				}
+			}
+			for a := start[v]; a < start[v+1]; a++ {
+				side.reach(s.stamp, to[a], v, mid[a], item.f+cost[a])
+			}
+			return true
+		}
+		return false
+	}
+	for s.forward.open.Len() > 0 || s.back.open.Len() > 0 {
+		if s.back.open.Len() == 0 || (s.forward.open.Len() > 0 && s.forward.open[0].f <= s.back.open[0].f) {
+			settle(&s.forward, &s.back, ch.UpStart, ch.UpTo, ch.UpCost, ch.UpMid, ch.DownStart, ch.DownFrom, ch.DownCost)
+		} else {
+			settle(&s.back, &s.forward, ch.DownStart, ch.DownFrom, ch.DownCost, ch.DownMid, ch.UpStart, ch.UpTo, ch.UpCost)
+		}
+	}
+	if meet < 0 {
+		return nil, math.Inf(1)
+	}
+
+	// Walk back to the source, then out to the target, unpacking shortcuts
+	var chain []int32
+	for v := meet; s.forward.parent[v] >= 0; v = s.forward.parent[v] {
+		chain = append(chain, v)
+	}
+	first := meet
+	if len(chain) > 0 {
+		first = s.forward.parent[chain[len(chain)-1]]
+	}
+	states := []int32{first}
+	for i := len(chain) - 1; i >= 0; i-- {
+		v := chain[i]
+		states = ch.unpack(s.forward.parent[v], v, s.forward.mid[v], states)
+	}
+	for v := meet; s.back.parent[v] >= 0; v = s.back.parent[v] {
+		states = ch.unpack(v, s.back.parent[v], s.back.mid[v], states)
+	}
+	return states, best
+}
+
+// unpack appends the states the arc u -> x passes through after u, ending
+// with x.
+func (ch *ContractionHierarchy) unpack(u, x, mid int32, states []int32) []int32 {
+	if mid < 0 {
+		return append(states, x)
+	}
+	// u -> mid is stored going down into mid, mid -> x going up out of it
+	if a := ch.downArc(u, mid); a >= 0 {
+		states = ch.unpack(u, mid, ch.DownMid[a], states)
+	}
+	if a := ch.upArc(mid, x); a >= 0 {
+		states = ch.unpack(mid, x, ch.UpMid[a], states)
+	}
+	return states
+}
+
+// snapEnds turns a route between snapped points into query ends. A route
+// may stop on any edge into the node an entry starts from, paying for the
+// turn onto the entry's edge, just as routeBetween's search does.
+func (ch *ContractionHierarchy) snapEnds(g *RoadGraph, model CostModel, src, dst EdgeSnap) (sources, targets []chEnd) {
+	for _, exit := range g.exits(model, src) {
+		sources = append(sources, chEnd{exit.edge, exit.cost})
+	}
+	for _, entry := range g.entries(model, dst) {
+		for k := ch.inStart[entry.node]; k < ch.inStart[entry.node+1]; k++ {
+			in := ch.inEdge[k]
+			if entry.cost == 0 {
+				targets = append(targets, chEnd{in, 0})
+			} else if seconds, ok := g.Turn(in, entry.edge); ok {
+				targets = append(targets, chEnd{in, model.TurnCost(g, seconds) + entry.cost})
+			}
+		}
+	}
+	return sources, targets
+}
+
+// nodeEnds turns a route between two nodes into query ends.
+func (ch *ContractionHierarchy) nodeEnds(g *RoadGraph, model CostModel, start, goal int32) (sources, targets []chEnd) {
+	lo, hi := g.Edges(start)
+	for e := lo; e < hi; e++ {
+		sources = append(sources, chEnd{e, model.EdgeCost(g, e)})
+	}
+	for k := ch.inStart[goal]; k < ch.inStart[goal+1]; k++ {
+		targets = append(targets, chEnd{ch.inEdge[k], 0})
+	}
+	return sources, targets
+}
+
+// statePath lists the nodes a chain of states arrives at.
+func statePath(g *RoadGraph, states []int32) []GraphNode {
+	path := make([]GraphNode, len(states))
+	for i, e := range states {
+		path[i] = g.Node(g.EdgeTo[e])
+	}
+	return path
+}
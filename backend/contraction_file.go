@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// A contraction hierarchy is saved next to its graph, graph/graph.json.ch for
+// graph/graph.json, and used whenever it is found there. A JSON graph and
+// its binary conversion each get their own, since the binary format's
+// float32 distances price routes very slightly differently.
+// Everything is little-endian; each section starts on an 8-byte boundary.
+//
+//	header (32 bytes)
+//	  magic      [8]byte "RSCHIER\x00"
+//	  version    uint32
+//	  states     uint32  the graph's edges
+//	  up, down   uint32 each, arc counts
+//	  graph      uint32  fingerprint of the turns it was built from
+//	  checksum   uint32  CRC-32C of everything after the header
+//	UpStart    [states+1]int32
+//	UpTo, UpMid      [up]int32 each
+//	UpCost           [up]float64
+//	DownStart  [states+1]int32
+//	DownFrom, DownMid  [down]int32 each
+//	DownCost           [down]float64
+const (
+	contractionMagic   = "RSCHIER\x00"
+	contractionVersion = 1
+	contractionHeader  = 32
+)
+
+// contractionPath is where the hierarchy for the graph at graphPath lives.
+func contractionPath(graphPath string) string {
+	return graphPath + ".ch"
+}
+
+// turnFingerprint identifies the turn graph g has under model, so a
+// hierarchy built before the graph or the costs changed is not used.
+func turnFingerprint(g *RoadGraph, model CostModel) uint32 {
+	h := crc32.New(castagnoli)
+	var buf [16]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(len(g.EdgeTo)))
+	h.Write(buf[:4])
+	turnArcs(g, model, func(in, out int32, cost float64) {
+		binary.LittleEndian.PutUint32(buf[0:], uint32(in))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(out))
+		binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(cost))
+		h.Write(buf[:])
+	})
+	return h.Sum32()
+}
+
+func writeContraction(path string, ch *ContractionHierarchy, fingerprint uint32) error {
+	var body bytes.Buffer
+	section := func(v any) {
+		binary.Write(&body, binary.LittleEndian, v)
+		body.Write(make([]byte, (8-body.Len()%8)%8))
+	}
+	section(ch.UpStart)
+	section(ch.UpTo)
+	section(ch.UpMid)
+	section(ch.UpCost)
+	section(ch.DownStart)
+	section(ch.DownFrom)
+	section(ch.DownMid)
+	section(ch.DownCost)
+
+	header := make([]byte, contractionHeader)
+	copy(header, contractionMagic)
+	binary.LittleEndian.PutUint32(header[8:], contractionVersion)
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(ch.UpStart)-1))
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(ch.UpTo)))
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(ch.DownFrom)))
+	binary.LittleEndian.PutUint32(header[24:], fingerprint)
+	binary.LittleEndian.PutUint32(header[28:], crc32.Checksum(body.Bytes(), castagnoli))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating hierarchy: %w", err)
+	}
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return fmt.Errorf("writing hierarchy: %w", err)
+	}
+	if _, err := body.WriteTo(file); err != nil {
+		file.Close()
+		return fmt.Errorf("writing hierarchy: %w", err)
+	}
+	return file.Close()
+}
+
+// readContraction decodes a hierarchy for g, checking it was built from g's
+// turns under model.
+func readContraction(data []byte, g *RoadGraph, model CostModel) (*ContractionHierarchy, error) {
+	if len(data) < contractionHeader || !bytes.HasPrefix(data, []byte(contractionMagic)) {
+		return nil, errors.New("not a contraction hierarchy")
+	}
+	le := binary.LittleEndian
+	if v := le.Uint32(data[8:]); v != contractionVersion {
+		return nil, fmt.Errorf("format version %d, this build reads version %d", v, contractionVersion)
+	}
+	n := int(le.Uint32(data[12:]))
+	up, down := int(le.Uint32(data[16:])), int(le.Uint32(data[20:]))
+	body := data[contractionHeader:]
+	if crc32.Checksum(body, castagnoli) != le.Uint32(data[28:]) {
+		return nil, errors.New("checksum mismatch; the file is corrupt or truncated")
+	}
+	if n != len(g.EdgeTo) || le.Uint32(data[24:]) != turnFingerprint(g, model) {
+		return nil, errors.New("built for a different graph or different costs")
+	}
+
+	ch := &ContractionHierarchy{
+		Model:     model,
+		UpStart:   make([]int32, n+1),
+		UpTo:      make([]int32, up),
+		UpMid:     make([]int32, up),
+		UpCost:    make([]float64, up),
+		DownStart: make([]int32, n+1),
+		DownFrom:  make([]int32, down),
+		DownMid:   make([]int32, down),
+		DownCost:  make([]float64, down),
+	}
+	r := bytes.NewReader(body)
+	for _, v := range []any{ch.UpStart, ch.UpTo, ch.UpMid, ch.UpCost, ch.DownStart, ch.DownFrom, ch.DownMid, ch.DownCost} {
+		if err := binary.Read(r, le, v); err != nil {
+			return nil, errors.New("file is shorter than its header says")
+		}
+		r.Seek(int64((8-(len(body)-r.Len())%8)%8), io.SeekCurrent)
+	}
+
+	// Make sure no arc can index out of range
+	check := func(start, to, mid []int32, arcs int) error {
+		if start[0] != 0 || int(start[n]) != arcs {
+			return errors.New("arc offsets don't cover the arc list")
+		}
+		for v := 0; v < n; v++ {
+			if start[v] > start[v+1] {
+				return fmt.Errorf("arc offsets go backwards at state %d", v)
+			}
+		}
+		for a := range to {
+			if to[a] < 0 || int(to[a]) >= n || mid[a] < -1 || int(mid[a]) >= n {
+				return fmt.Errorf("arc %d names a state past the last one", a)
+			}
+		}
+		return nil
+	}
+	if err := check(ch.UpStart, ch.UpTo, ch.UpMid, up); err != nil {
+		return nil, err
+	}
+	if err := check(ch.DownStart, ch.DownFrom, ch.DownMid, down); err != nil {
+		return nil, err
+	}
+	// and that every shortcut can be unpacked into the arcs it skips
+	for u := int32(0); u < int32(n); u++ {
+		for a := ch.UpStart[u]; a < ch.UpStart[u+1]; a++ {
+			if mid := ch.UpMid[a]; mid >= 0 && (ch.downArc(u, mid) < 0 || ch.upArc(mid, ch.UpTo[a]) < 0) {
+				return nil, fmt.Errorf("shortcut %d skips a state it has no arcs through", a)
+			}
+		}
+		for a := ch.DownStart[u]; a < ch.DownStart[u+1]; a++ {
+			if mid := ch.DownMid[a]; mid >= 0 && (ch.downArc(ch.DownFrom[a], mid) < 0 || ch.upArc(mid, u) < 0) {
+				return nil, fmt.Errorf("shortcut %d skips a state it has no arcs through", a)
+			}
+		}
+	}
+	ch.prepare(g)
+	return ch, nil
+}
+
+// loadContraction attaches the hierarchy saved next to the graph at
+// graphPath to g, if there is one that fits.
+func loadContraction(g *RoadGraph, graphPath string) {
+	path := contractionPath(graphPath)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		g.CH, err = readContraction(data, g, routeCost)
+	}
+	if err != nil {
+		log.Printf("⚠️ Ignoring %s: %v; rebuild it with `ridesync build-ch`\n", path, err)
+		return
+	}
+	log.Printf("Routing with the contraction hierarchy in %s\n", path)
+}
+
+// runBuildCHCommand implements `ridesync build-ch`: it contracts a graph and
+// saves the hierarchy next to it.
+func runBuildCHCommand(args []string) error {
+	fs := flag.NewFlagSet("build-ch", flag.ExitOnError)
+	graphPath := fs.String("graph", "graph/graph.json", "road graph to contract, JSON or binary")
+	fs.Parse(args)
+
+	loadGraph(*graphPath)
+	g := roadGraph
+	started := time.Now()
+	ch := buildContraction(g, routeCost)
+	path := contractionPath(*graphPath)
+	if err := writeContraction(path, ch, turnFingerprint(g, routeCost)); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Contracted %d states in %s, %d shortcuts; wrote %s\n",
+		len(g.EdgeTo), time.Since(started).Round(time.Millisecond), countShortcuts(ch), path)
+	return nil
+}
+
+func countShortcuts(ch *ContractionHierarchy) int {
+	n := 0
+	for _, mids := range [][]int32{ch.UpMid, ch.DownMid} {
+		for _, m := range mids {
+			if m >= 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// runCheckCHCommand implements `ridesync check-ch`: it routes between random
+// points with the hierarchy and with a plain Dijkstra search and compares
+// the costs.
+func runCheckCHCommand(args []string) error {
+	fs := flag.NewFlagSet("check-ch", flag.ExitOnError)
+	graphPath := fs.String("graph", "graph/graph.json", "road graph whose hierarchy to check")
+	queries := fs.Int("queries", 1000, "random routes to compare")
+	seed := fs.Int64("seed", 1, "seed for picking the routes")
+	fs.Parse(args)
+
+	loadGraph(*graphPath)
+	g := roadGraph
+	if g.CH == nil {
+		return fmt.Errorf("no usable hierarchy at %s; build one with `ridesync build-ch`", contractionPath(*graphPath))
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	var chTime, dijkstraTime time.Duration
+	mismatches := 0
+	for q := 0; q < *queries; q++ {
+		src, ok1 := getRandomRoadPoint(g, rng)
+		dst, ok2 := getRandomRoadPoint(g, rng)
+		if !ok1 || !ok2 {
+			return fmt.Errorf("the graph has no roads to route between")
+		}
+		sources, targets := g.CH.snapEnds(g, g.CH.Model, src, dst)
+
+		started := time.Now()
+		states, cost := g.CH.route(sources, targets)
+		chTime += time.Since(started)
+		started = time.Now()
+		_, want := dijkstraRoute(g, g.CH.Model, sources, targets)
+		dijkstraTime += time.Since(started)
+
+		// The unpacked route must cost what the query said, too
+		walked := math.Inf(1)
+		if states != nil {
+			walked = pathCost(g, g.CH.Model, states, sources, targets)
+		}
+		if !sameCost(cost, want) || !sameCost(walked, want) {
+			mismatches++
+			if mismatches <= 10 {
+				fmt.Printf("❌ Route %d from edge %d to edge %d: hierarchy %.6f (route walked %.6f), Dijkstra %.6f\n",
+					q, src.Edge, dst.Edge, cost, walked, want)
+			}
+		}
+	}
+	fmt.Printf("Hierarchy %v per route, Dijkstra %v\n", chTime/time.Duration(*queries), dijkstraTime/time.Duration(*queries))
+	if mismatches > 0 {
+		return fmt.Errorf("%d of %d routes differ", mismatches, *queries)
+	}
+	fmt.Printf("✅ All %d routes cost the same\n", *queries)
+	return nil
+}
+
+// sameCost compares route costs, allowing for shortcuts adding the same
+// numbers in a different order.
+func sameCost(a, b float64) bool {
+	if math.IsInf(a, 1) || math.IsInf(b, 1) {
+		return math.IsInf(a, 1) && math.IsInf(b, 1)
+	}
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
+
+// dijkstraRoute is the plain search the hierarchy must agree with: Dijkstra
+// over the same states, from any source to any target.
+func dijkstraRoute(g *RoadGraph, model CostModel, sources, targets []chEnd) ([]int32, float64) {
+	s := acquireSearch(g)
+	defer searchPool.Put(s)
+	offset := map[int32]float64{}
+	for _, t := range targets {
+		if c, ok := offset[t.state]; !ok || t.cost < c {
+			offset[t.state] = t.cost
+		}
+	}
+	for _, e := range sources {
+		if s.seen[e.state] != s.stamp || e.cost < s.g[e.state] {
+			s.visit(e.state, -1, e.cost)
+			heap.Push(&s.open, openItem{edge: e.state, f: e.cost})
+		}
+	}
+	zero := func(int32) float64 { return 0 }
+	best, bestState := math.Inf(1), int32(-1)
+	for s.open.Len() > 0 {
+		item := heap.Pop(&s.open).(openItem)
+		if item.f >= best {
+			break
+		}
+		if s.closed[item.edge] == s.stamp {
+			continue
+		}
+		s.closed[item.edge] = s.stamp
+		if c, ok := offset[item.edge]; ok && item.f+c < best {
+			best, bestState = item.f+c, item.edge
+		}
+		s.relax(g, model, item.edge, zero)
+	}
+	if bestState < 0 {
+		return nil, best
+	}
+	var states []int32
+	for e := bestState; e != -1; e = s.parent[e] {
+		states = append([]int32{e}, states...)
+	}
+	return states, best
+}
+
+// pathCost adds up what driving states costs, from the start through to
+// the finish.
+func pathCost(g *RoadGraph, model CostModel, states []int32, sources, targets []chEnd) float64 {
+	cost := math.Inf(1)
+	for _, e := range sources {
+		if e.state == states[0] && e.cost < cost {
+			cost = e.cost
+		}
+	}
+	for i := 1; i < len(states); i++ {
+		seconds, ok := g.Turn(states[i-1], states[i])
+		if !ok || g.EdgeTo[states[i-1]] != g.edgeFrom(states[i]) {
+			return math.Inf(1)
+		}
+		cost += model.TurnCost(g, seconds) + model.EdgeCost(g, states[i])
+	}
+	last := math.Inf(1)
+	for _, t := range targets {
+		if t.state == states[len(states)-1] && t.cost < last {
+			last = t.cost
+		}
+	}
+	return cost + last
+}
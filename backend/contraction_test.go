@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// turnyGrid is a grid with one-way streets and turn rules of every kind,
+// so routes have to go round banned turns.
+func turnyGrid(rows, cols int) *RoadGraph {
+	nodes := testGridNodes(rows, cols)
+	key := func(r, c int) string { return strconv.Itoa(r*cols + c + 1) }
+	for r := 1; r < rows-1; r++ {
+		for c := 1; c < cols-1; c++ {
+			node := nodes[key(r, c)]
+			switch (r*cols + c) % 7 {
+			case 0:
+				node.Turns = append(node.Turns, TurnRule{From: key(r, c-1), To: key(r+1, c), Kind: "no"})
+			case 3:
+				node.Turns = append(node.Turns, TurnRule{From: key(r-1, c), To: key(r+1, c), Kind: "only"})
+			case 5:
+				node.Turns = append(node.Turns, TurnRule{From: key(r, c+1), To: key(r-1, c), Kind: "penalty", Seconds: 30})
+			}
+			nodes[key(r, c)] = node
+		}
+	}
+	// Row 3 runs one way, east
+	for c := 1; c < cols; c++ {
+		delete(nodes[key(3, c)].Neighbors, key(3, c-1))
+	}
+	return buildRoadGraph(nodes)
+}
+
+func TestContractionMatchesDijkstra(t *testing.T) {
+	g := turnyGrid(12, 12)
+	if len(g.TurnIn) < 30 {
+		t.Fatalf("test graph has only %d turn rules", len(g.TurnIn))
+	}
+	built := buildContraction(g, routeCost)
+
+	// Saved and loaded again, it must answer the same
+	path := filepath.Join(t.TempDir(), "grid.json.ch")
+	if err := writeContraction(path, built, turnFingerprint(g, routeCost)); err != nil {
+		t.Fatal(err)
+	}
+	loadContraction(g, filepath.Join(filepath.Dir(path), "grid.json"))
+	if g.CH == nil {
+		t.Fatal("saved hierarchy was not loaded")
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	for _, ch := range []*ContractionHierarchy{built, g.CH} {
+		for q := 0; q < 300; q++ {
+			var sources, targets []chEnd
+			if q%2 == 0 {
+				start, goal := int32(rng.Intn(g.NumNodes())), int32(rng.Intn(g.NumNodes()))
+				if start == goal {
+					continue
+				}
+				sources, targets = ch.nodeEnds(g, routeCost, start, goal)
+			} else {
+				src, _ := getRandomRoadPoint(g, rng)
+				dst, _ := getRandomRoadPoint(g, rng)
+				sources, targets = ch.snapEnds(g, routeCost, src, dst)
+			}
+			states, cost := ch.route(sources, targets)
+			_, want := dijkstraRoute(g, routeCost, sources, targets)
+			if !sameCost(cost, want) {
+				t.Errorf("route %d: hierarchy costs %v, Dijkstra %v", q, cost, want)
+				continue
+			}
+			if states != nil {
+				if walked := pathCost(g, routeCost, states, sources, targets); !sameCost(walked, want) {
+					t.Errorf("route %d: unpacked route costs %v, Dijkstra %v", q, walked, want)
+				}
+			}
+		}
+	}
+}
+
+func TestContractionRejectsOtherGraph(t *testing.T) {
+	g := turnyGrid(6, 6)
+	path := filepath.Join(t.TempDir(), "grid.json.ch")
+	if err := writeContraction(path, buildContraction(g, routeCost), turnFingerprint(g, routeCost)); err != nil {
+		t.Fatal(err)
+	}
+	other := testGrid(6, 6) // same edges, no turn rules
+	loadContraction(other, filepath.Join(filepath.Dir(path), "grid.json"))
+	if other.CH != nil {
+		t.Error("hierarchy built with turn rules was used on a graph without them")
+	}
+}
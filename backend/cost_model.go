@@ -0,0 +1,74 @@
+package main
+
+const (
+	defaultSpeed = 40.0  // km/h, used when an edge has no speed
+	fuelPerMeter = 0.001 // liters
+)
+
+// CostModel prices edges for the router. TurnCost prices a turn that holds
+// the vehicle up for seconds. LowerBound turns a distance the caller
+// guarantees is no longer than any real route into a cost in the same unit
+// as EdgeCost; it must never overestimate or A* stops being optimal.
+type CostModel interface {
+	EdgeCost(g *RoadGraph, e int32) float64
+	TurnCost(g *RoadGraph, seconds float64) float64
+	LowerBound(g *RoadGraph, meters float64) float64
+}
+
+// TravelTimeCost prices edges in seconds, including the expected wait at the
+// node the edge arrives at.
+type TravelTimeCost struct{}
+
+func (TravelTimeCost) EdgeCost(g *RoadGraph, e int32) float64 {
+	seconds := g.EdgeDist.At(e) / (g.Speed(e) * 1000.0 / 3600.0)
+
+	to := g.EdgeTo[e]
+	if g.TrafficLight[to] {
+		seconds += 0.33 * 15.0 // stop at ~1 in 3 lights for 15s
+	} else if g.StopSign[to] {
+		seconds += 1.0 // soft penalty for ETA only
+	}
+	return seconds
+}
+
+func (TravelTimeCost) TurnCost(g *RoadGraph, seconds float64) float64 {
+	return seconds
+}
+
+func (TravelTimeCost) LowerBound(g *RoadGraph, meters float64) float64 {
+	return meters / (g.MaxSpeed * 1000.0 / 3600.0)
+}
+
+// DistanceCost prices edges in meters.
+type DistanceCost struct{}
+
+func (DistanceCost) EdgeCost(g *RoadGraph, e int32) float64 {
+	return g.EdgeDist.At(e)
+}
+
+// TurnCost is zero: waiting to turn adds no distance.
+func (DistanceCost) TurnCost(g *RoadGraph, seconds float64) float64 {
+	return 0
+}
+
+func (DistanceCost) LowerBound(g *RoadGraph, meters float64) float64 {
+	return meters
+}
+
+// FuelCost prices edges in liters at a flat consumption rate.
+type FuelCost struct {
+	LitersPerMeter float64
+}
+
+func (f FuelCost) EdgeCost(g *RoadGraph, e int32) float64 {
+	return g.EdgeDist.At(e) * f.LitersPerMeter
+}
+
+// TurnCost is zero: idling at a turn is not counted.
+func (f FuelCost) TurnCost(g *RoadGraph, seconds float64) float64 {
+	return 0
+}
+
+func (f FuelCost) LowerBound(g *RoadGraph, meters float64) float64 {
+	return meters * f.LitersPerMeter
+}
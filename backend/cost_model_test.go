@@ -0,0 +1,146 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"testing"
+)
+
+var testModels = []struct {
+	name  string
+	model CostModel
+}{
+	{"travel time", TravelTimeCost{}},
+	{"distance", DistanceCost{}},
+	{"fuel", FuelCost{LitersPerMeter: fuelPerMeter}},
+}
+
+// nodeCosts runs a plain Dijkstra search out of start and returns the
+// cheapest cost to every node, +Inf where there is no route.
+func nodeCosts(g *RoadGraph, model CostModel, start int32) []float64 {
+	costs := make([]float64, g.NumNodes())
+	for i := range costs {
+		costs[i] = math.Inf(1)
+	}
+	costs[start] = 0
+	s := acquireSearch(g)
+	defer searchPool.Put(s)
+	lo, hi := g.Edges(start)
+	for e := lo; e < hi; e++ {
+		s.visit(e, -1, model.EdgeCost(g, e))
+		heap.Push(&s.open, openItem{edge: e, f: s.g[e]})
+	}
+	zero := func(int32) float64 { return 0 }
+	for s.open.Len() > 0 {
+		item := heap.Pop(&s.open).(openItem)
+		if s.closed[item.edge] == s.stamp {
+			continue
+		}
+		s.closed[item.edge] = s.stamp
+		if to := g.EdgeTo[item.edge]; item.f < costs[to] {
+			costs[to] = item.f
+		}
+		s.relax(g, model, item.edge, zero)
+	}
+	return costs
+}
+
+// pathCostUnder prices a route of nodes the way the router does.
+func pathCostUnder(t *testing.T, g *RoadGraph, model CostModel, path []GraphNode) float64 {
+	t.Helper()
+	cost, prev := 0.0, int32(-1)
+	for i := 1; i < len(path); i++ {
+		e, ok := g.findEdgeByID(path[i-1].ID, path[i].ID)
+		if !ok {
+			t.Fatalf("no edge %d -> %d on the route", path[i-1].ID, path[i].ID)
+		}
+		if prev >= 0 {
+			seconds, ok := g.Turn(prev, e)
+			if !ok {
+				t.Fatalf("route makes a banned turn %d -> %d", path[i-1].ID, path[i].ID)
+			}
+			cost += model.TurnCost(g, seconds)
+		}
+		cost += model.EdgeCost(g, e)
+		prev = e
+	}
+	return cost
+}
+
+func TestEdgeCost(t *testing.T) {
+	g := testGrid(2, 2)
+	// Node 0 has a light; 0 -> 1 is a 50 km/h street, 1 -> 0 the same street
+	// back into the light, 2 -> 3 an untagged one
+	e01, _ := g.FindEdge(0, 1)
+	e10, _ := g.FindEdge(1, 0)
+	e23, _ := g.FindEdge(2, 3)
+	tests := []struct {
+		name  string
+		model CostModel
+		edge  int32
+		want  float64
+	}{
+		{"travel time", TravelTimeCost{}, e01, g.EdgeDist.At(e01) / (50 / 3.6)},
+		{"travel time into a light", TravelTimeCost{}, e10, g.EdgeDist.At(e10)/(50/3.6) + 0.33*15},
+		{"travel time on an untagged street", TravelTimeCost{}, e23, g.EdgeDist.At(e23) / (defaultSpeed / 3.6)},
+		{"distance", DistanceCost{}, e01, g.EdgeDist.At(e01)},
+		{"fuel", FuelCost{LitersPerMeter: 0.002}, e01, g.EdgeDist.At(e01) * 0.002},
+	}
+	for _, tt := range tests {
+		if got := tt.model.EdgeCost(g, tt.edge); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: EdgeCost = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLowerBoundAdmissible(t *testing.T) {
+	g := testGrid(7, 9)
+	for _, tt := range testModels {
+		for _, start := range []int32{0, 13, 31, 62} {
+			costs := nodeCosts(g, tt.model, start)
+			for goal := int32(0); goal < int32(g.NumNodes()); goal++ {
+				if h := heuristic(g, tt.model, start, goal); h > costs[goal]+1e-9 {
+					t.Errorf("%s: %d -> %d: heuristic %v exceeds the cheapest route %v",
+						tt.name, start, goal, h, costs[goal])
+				}
+			}
+		}
+	}
+}
+
+func TestRoutesOptimalAndRepeatable(t *testing.T) {
+	nodes := testGridNodes(7, 9)
+	g := testGrid(7, 9)
+	// Built again from the same nodes, which a map hands over in another order
+	again := buildRoadGraph(nodes)
+	pairs := [][2]int32{{0, 62}, {8, 54}, {13, 40}, {62, 0}, {30, 31}}
+	for _, tt := range testModels {
+		for _, p := range pairs {
+			path := aStarIndexed(g, tt.model, p[0], p[1])
+			if path == nil {
+				t.Fatalf("%s: no route %d -> %d", tt.name, p[0], p[1])
+			}
+			want := nodeCosts(g, tt.model, p[0])[p[1]]
+			if got := pathCostUnder(t, g, tt.model, path); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: %d -> %d costs %v, the cheapest route %v", tt.name, p[0], p[1], got, want)
+			}
+			for _, other := range [][]GraphNode{aStarIndexed(g, tt.model, p[0], p[1]), aStarIndexed(again, tt.model, p[0], p[1])} {
+				if !sameNodes(path, other) {
+					t.Errorf("%s: %d -> %d gave two different routes", tt.name, p[0], p[1])
+				}
+			}
+		}
+	}
+}
+
+func sameNodes(a, b []GraphNode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
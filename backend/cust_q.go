@@ -5,7 +5,7 @@ import (
 	"net/http"
 )
 
-func getCustQ(w http.ResponseWriter, r *http.Request) {
+func getCustQ(sim *Simulation, w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodOptions {
 		// Handle preflight requests
@@ -35,7 +35,7 @@ func getCustQ(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Replace with your frontend domain
 	w.WriteHeader(http.StatusOK)
 	custq := CustQ{
-		CustQ: customerQueue,
+		CustQ: sim.queueSnapshot(),
 	}
 
 	json.NewEncoder(w).Encode(custq)
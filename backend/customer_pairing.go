@@ -2,17 +2,16 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
-	"math"
 	"net/http"
 )
 
-func getPairing(w http.ResponseWriter, r *http.Request) {
+func getPairing(sim *Simulation, w http.ResponseWriter, r *http.Request) {
 	type Pairing struct {
-		IdealDriver     int        `json:"idealDriver"`
-		CurrentCustomer Customer   `json:"currentCustomer"`
-		Drivers         []Driver   `json:"drivers"`
-		CustQue         []Customer `json:"custque"`
+		IdealDriver     int               `json:"idealDriver"`
+		CurrentCustomer Customer          `json:"currentCustomer"`
+		Drivers         []Driver          `json:"drivers"`
+		CustQue         []Customer        `json:"custque"`
+		Candidates      []DriverCandidate `json:"candidates"` // ranked by ETA, best first
 	}
 
 	if r.Method == http.MethodOptions {
@@ -41,34 +40,30 @@ func getPairing(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Replace with your frontend domain
 	w.WriteHeader(http.StatusOK)
 
-	queueMutex.Lock()
+	// Without a posted fleet, preview against the live one
+	if len(requestData.Drivers) == 0 {
+		requestData.Drivers = sim.driverSnapshot()
+	}
+
+	queue := sim.queueSnapshot()
 	var customer Customer
-	if len(customerQueue) > 0 {
-		customer = customerQueue[0] // Peek instead of dequeue
+	if len(queue) > 0 {
+		customer = queue[0] // Peek instead of dequeue
 	}
-	queueMutex.Unlock()
 	pairing := Pairing{
 		IdealDriver:     -1,
 		CurrentCustomer: customer,
 		Drivers:         requestData.Drivers,
-		CustQue:         customerQueue,
+		CustQue:         queue,
 	}
-	leastDistance := 50000
-	for i := 0; i < len(requestData.Drivers); i++ {
-		dis := math.Abs(float64(requestData.Drivers[i].Lat-customer.Lat)) + math.Abs(float64(requestData.Drivers[i].Lon-customer.Lon))
-
-		if int(dis) < leastDistance && !requestData.Drivers[i].HasCustomer {
-
-			leastDistance = int(dis)
-			pairing.IdealDriver = i
-
-		}
+	if len(queue) > 0 {
+		pairing.Candidates = rankDrivers(sim.matchEnv(), requestData.Drivers, customer, matchCandidates)
+	}
+	if len(pairing.Candidates) > 0 {
+		pairing.IdealDriver = pairing.Candidates[0].Index
 	}
 	if pairing.IdealDriver != -1 {
 		requestData.Drivers[pairing.IdealDriver].HasCustomer = true
-	} else {
-		fmt.Println("No Drivers Available you are in position ", len(customerQueue))
 	}
-	fmt.Println(customer)
 	json.NewEncoder(w).Encode(pairing)
 }
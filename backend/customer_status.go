@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Customer statuses. A customer only moves forward through these, and may
+// only be cancelled or expire before they are picked up.
+const (
+	CustomerRequested      = "requested"      // waiting in the queue
+	CustomerMatched        = "matched"        // on a driver's route, not the next stop yet
+	CustomerDriverArriving = "driverArriving" // their pickup is the driver's next stop
+	CustomerOnTrip         = "onTrip"
+	CustomerCompleted      = "completed"
+	CustomerCancelled      = "cancelled"
+	CustomerExpired        = "expired" // waited longer than the simulation's patience
+)
+
+// customerTransitions lists the statuses each status may move to.
+var customerTransitions = map[string][]string{
+	CustomerRequested:      {CustomerMatched, CustomerCancelled, CustomerExpired},
+	CustomerMatched:        {CustomerDriverArriving, CustomerCancelled},
+	CustomerDriverArriving: {CustomerOnTrip, CustomerCancelled},
+	CustomerOnTrip:         {CustomerCompleted},
+}
+
+// defaultPatience is how long a customer waits for a match before giving up.
+const defaultPatience = 15 * time.Minute
+
+// setStatus moves c to status, recording at as when it happened. It returns
+// false and leaves c alone if the lifecycle doesn't allow the move.
+func (c *Customer) setStatus(status string, at time.Time) bool {
+	allowed := false
+	for _, next := range customerTransitions[c.Status] {
+		if next == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return false
+	}
+
+	c.Status = status
+	switch status {
+	case CustomerMatched:
+		c.MatchedAt = at
+	case CustomerDriverArriving:
+		c.DriverArrivingAt = at
+	case CustomerOnTrip:
+		c.PickedUpAt = at
+	case CustomerCompleted:
+		c.CompletedAt = at
+	case CustomerCancelled:
+		c.CancelledAt = at
+	case CustomerExpired:
+		c.ExpiredAt = at
+	}
+	return true
+}
+
+// setRiderStatus updates the customer with id on every stop that carries
+// them. It copies the stop list first, since events already published may
+// still share it.
+func (d *Driver) setRiderStatus(id int, status string, at time.Time) {
+	d.Stops = append([]Stop(nil), d.Stops...)
+	for i := range d.Stops {
+		if d.Stops[i].Customer.Id == id {
+			d.Stops[i].Customer.setStatus(status, at)
+		}
+	}
+}
+
+// expireWaiting drops customers who have waited for a match longer than the
+// simulation's patience. A zero patience keeps them waiting forever.
+func (s *Simulation) expireWaiting(now time.Time) {
+	patience := s.Config.Patience
+	if patience <= 0 {
+		return
+	}
+
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	kept := make([]Customer, 0, len(s.Queue))
+	for _, c := range s.Queue {
+		if now.Sub(c.RequestedAt) < patience {
+			kept = append(kept, c)
+			continue
+		}
+		// The dispatcher may notice late; they gave up when patience ran out
+		c.setStatus(CustomerExpired, c.RequestedAt.Add(patience))
+		s.Stats.CustomersExpired++
+		s.Events.Publish(LiveEvent{Type: EventCustomerExpired, Time: now, Data: c})
+		fmt.Printf("⌛ %s gave up waiting for a driver\n", c.Name)
+	}
+	s.Queue = kept
+}
+
+var (
+	errCustomerNotFound = errors.New("customer not found")
+	errCustomerOnTrip   = errors.New("customer has already been picked up")
+)
+
+// cancelCustomer takes a customer out of the queue or off their driver's
+// route. A driver left with nothing to do goes back to roaming; one still
+// carrying others heads for its next stop.
+func (s *Simulation) cancelCustomer(id int, now time.Time) (Customer, error) {
+	s.driverMu.Lock()
+	defer s.driverMu.Unlock()
+
+	s.queueMu.Lock()
+	for i, c := range s.Queue {
+		if c.Id == id {
+			s.Queue = append(s.Queue[:i], s.Queue[i+1:]...)
+			s.queueMu.Unlock()
+			c.setStatus(CustomerCancelled, now)
+			s.Events.Publish(LiveEvent{Type: EventCustomerCancelled, Time: now, Data: c})
+			return c, nil
+		}
+	}
+	s.queueMu.Unlock()
+
+	for i := range s.Drivers {
+		driver := &s.Drivers[i]
+		pickup := -1
+		for j, stop := range driver.Stops {
+			if stop.Customer.Id != id {
+				continue
+			}
+			if stop.Kind == StopDropoff && pickup < 0 {
+				return stop.Customer, errCustomerOnTrip
+			}
+			pickup = j
+			break
+		}
+		if pickup < 0 {
+			continue
+		}
+
+		c := driver.Stops[pickup].Customer
+		c.setStatus(CustomerCancelled, now)
+		kept := make([]Stop, 0, len(driver.Stops))
+		for _, stop := range driver.Stops {
+			if stop.Customer.Id != id {
+				kept = append(kept, stop)
+			}
+		}
+		driver.Stops = kept
+		driver.syncStops(now)
+		s.openTrips.cancelled(driver.Name, c)
+		s.Events.Publish(LiveEvent{Type: EventCustomerCancelled, Time: now, Driver: driver.Name, Data: c})
+
+		// Only a driver that was heading for this pickup needs a new route
+		if pickup == 0 {
+			s.routeToNextStop(driver, now)
+			oldETA := driver.ETA
+			driver.ETA = estimateETA(s.Graph, driver.GraphPath)
+			s.publishRoute(driver, oldETA, now)
+		}
+		s.notifyDispatcher() // a seat just freed up
+		fmt.Printf("🚫 %s cancelled their ride with %s\n", c.Name, driver.Name)
+		return c, nil
+	}
+	return Customer{}, errCustomerNotFound
+}
+
+// CancelRequest is the body of POST /cancel-customer.
+type CancelRequest struct {
+	ID int `json:"id"`
+}
+
+// handleCancelCustomer serves /cancel-customer, returning the cancelled
+// customer.
+func handleCancelCustomer(sim *Simulation, w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		// Handle preflight requests
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request data", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	customer, err := sim.cancelCustomer(req.ID, sim.Clock.Now())
+	switch {
+	case errors.Is(err, errCustomerNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(customer)
+}
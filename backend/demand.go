@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// DemandProfile describes where and when customers ask for rides.
+type DemandProfile struct {
+	// Mean arrivals per simulated hour. 24 entries give one rate per hour of
+	// the day, starting at midnight; a single entry applies all day.
+	HourlyRates []float64    `json:"hourlyRates"`
+	Zones       []DemandZone `json:"zones"` // none means anywhere on the map
+	Names       []string     `json:"names"` // customer names to draw from
+}
+
+// DemandZone is an area customers are drawn to or from in proportion to its
+// weights. Weights are relative to the other zones'.
+type DemandZone struct {
+	Name         string  `json:"name"`
+	Lat          float64 `json:"lat"`
+	Lon          float64 `json:"lon"`
+	RadiusMeters float64 `json:"radiusMeters"` // 0 covers the whole map
+
+	OriginWeight      float64 `json:"originWeight"`
+	DestinationWeight float64 `json:"destinationWeight"`
+	// 24 entries override the flat weights hour by hour, for rush hours
+	// that run one way in the morning and the other in the evening
+	OriginHourly      []float64 `json:"originHourly"`
+	DestinationHourly []float64 `json:"destinationHourly"`
+}
+
+// defaultDemand is what customers look like without a profile: uniform
+// across the map, and only when someone asks for one.
+var defaultDemand = DemandProfile{Names: []string{"Ryan", "Luke", "Nancy", "Bob", "Jess"}}
+
+// uniformDemand is defaultDemand arriving at a constant rate.
+func uniformDemand(ratePerHour float64) *DemandProfile {
+	p := defaultDemand
+	p.HourlyRates = []float64{ratePerHour}
+	return &p
+}
+
+// loadDemandProfile reads a profile from a JSON file. Unknown fields are an
+// error so a misspelt weight doesn't silently become zero.
+func loadDemandProfile(path string) (*DemandProfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var p DemandProfile
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("reading demand profile %s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("demand profile %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *DemandProfile) validate() error {
+	if err := validateHourly("hourlyRates", p.HourlyRates, true); err != nil {
+		return err
+	}
+	for i, name := range p.Names {
+		if name == "" {
+			return fmt.Errorf("names[%d]: must not be empty", i)
+		}
+	}
+
+	var origins, destinations bool
+	for i, z := range p.Zones {
+		field := fmt.Sprintf("zones[%d]", i)
+		if z.Lat < -90 || z.Lat > 90 {
+			return fmt.Errorf("%s.lat: %v is not a latitude", field, z.Lat)
+		}
+		if z.Lon < -180 || z.Lon > 180 {
+			return fmt.Errorf("%s.lon: %v is not a longitude", field, z.Lon)
+		}
+		if z.RadiusMeters < 0 {
+			return fmt.Errorf("%s.radiusMeters: must be 0 or positive, got %v", field, z.RadiusMeters)
+		}
+		if z.OriginWeight < 0 {
+			return fmt.Errorf("%s.originWeight: must be 0 or positive, got %v", field, z.OriginWeight)
+		}
+		if z.DestinationWeight < 0 {
+			return fmt.Errorf("%s.destinationWeight: must be 0 or positive, got %v", field, z.DestinationWeight)
+		}
+		if err := validateHourly(field+".originHourly", z.OriginHourly, false); err != nil {
+			return err
+		}
+		if err := validateHourly(field+".destinationHourly", z.DestinationHourly, false); err != nil {
+			return err
+		}
+		origins = origins || z.OriginWeight > 0 || sum(z.OriginHourly) > 0
+		destinations = destinations || z.DestinationWeight > 0 || sum(z.DestinationHourly) > 0
+	}
+	if len(p.Zones) > 0 && !origins {
+		return fmt.Errorf("zones: no zone has an origin weight")
+	}
+	if len(p.Zones) > 0 && !destinations {
+		return fmt.Errorf("zones: no zone has a destination weight")
+	}
+	return nil
+}
+
+// validateHourly checks a list that is either empty or has one entry per
+// hour. allDay also allows a single entry.
+func validateHourly(field string, values []float64, allDay bool) error {
+	switch {
+	case len(values) == 0, len(values) == 24, allDay && len(values) == 1:
+	case allDay:
+		return fmt.Errorf("%s: want 1 or 24 entries, got %d", field, len(values))
+	default:
+		return fmt.Errorf("%s: want 24 entries, got %d", field, len(values))
+	}
+	for i, v := range values {
+		if v < 0 {
+			return fmt.Errorf("%s[%d]: must be 0 or positive, got %v", field, i, v)
+		}
+	}
+	return nil
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// hourly reads the value for hour from a 24-entry list, the only value of
+// a 1-entry list, or flat if the list is empty.
+func hourly(values []float64, hour int, flat float64) float64 {
+	switch len(values) {
+	case 0:
+		return flat
+	case 1:
+		return values[0]
+	}
+	return values[hour]
+}
+
+// Demand is a DemandProfile bound to the road graph it draws points from.
+type Demand struct {
+	Profile   *DemandProfile
+	g         *RoadGraph
+	zoneNodes [][]int32 // connected nodes inside each zone; nil for the whole map
+}
+
+// newDemand binds p to g. A zone with no roads in it is an error.
+func newDemand(p *DemandProfile, g *RoadGraph) (*Demand, error) {
+	if p == nil {
+		p = &defaultDemand
+	}
+	d := &Demand{Profile: p, g: g, zoneNodes: make([][]int32, len(p.Zones))}
+	for i, z := range p.Zones {
+		if z.RadiusMeters == 0 {
+			continue
+		}
+		d.zoneNodes[i] = g.Spatial.WithinRadius(z.Lat, z.Lon, z.RadiusMeters, NearestOptions{MinNeighbors: 1})
+		if len(d.zoneNodes[i]) == 0 {
+			return nil, fmt.Errorf("zones[%d] (%s): no roads within %gm of %v,%v", i, z.Name, z.RadiusMeters, z.Lat, z.Lon)
+		}
+	}
+	return d, nil
+}
+
+// never is the arrival time of a profile with no demand.
+var never = time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// rate is the arrival rate per hour at t.
+func (d *Demand) rate(t time.Time) float64 {
+	return hourly(d.Profile.HourlyRates, t.Hour(), 0)
+}
+
+// nextArrival returns the next arrival after t of a Poisson process whose
+// rate changes on the hour of t's location, the same hour rate reads. It
+// draws one exponential and spends it across as many hours as it takes.
+func (d *Demand) nextArrival(rng *rand.Rand, t time.Time) time.Time {
+	if sum(d.Profile.HourlyRates) == 0 {
+		return never
+	}
+	need := rng.ExpFloat64() // in expected arrivals
+	for {
+		rate := d.rate(t)
+		// Truncate would round on UTC, which is off the local hour in
+		// zones like +05:30
+		hourEnd := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(time.Hour)
+		if span := hourEnd.Sub(t).Hours(); rate*span < need {
+			need -= rate * span
+			t = hourEnd
+			continue
+		}
+		return t.Add(time.Duration(need / rate * float64(time.Hour)))
+	}
+}
+
+// pickZone chooses a zone by its origin or destination weight at hour, or -1
+// for anywhere on the map if there are no zones or none is weighted then.
+func (d *Demand) pickZone(rng *rand.Rand, hour int, origin bool) int {
+	weights := make([]float64, len(d.Profile.Zones))
+	total := 0.0
+	for i, z := range d.Profile.Zones {
+		if origin {
+			weights[i] = hourly(z.OriginHourly, hour, z.OriginWeight)
+		} else {
+			weights[i] = hourly(z.DestinationHourly, hour, z.DestinationWeight)
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return -1
+	}
+	x := rng.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return i
+		}
+		x -= w
+	}
+	return len(weights) - 1
+}
+
+// roadPoint picks a random spot on a road leaving a node in zone.
+func (d *Demand) roadPoint(rng *rand.Rand, zone int) (EdgeSnap, bool) {
+	if zone < 0 || d.zoneNodes[zone] == nil {
+		return getRandomRoadPoint(d.g, rng)
+	}
+	nodes := d.zoneNodes[zone]
+	return roadPointFrom(d.g, nodes[rng.Intn(len(nodes))], rng), true
+}
+
+// newCustomer creates a customer requesting a ride at now between two
+// mutually reachable road points in zones drawn from the profile. enqueue
+// gives them their ID.
+func (d *Demand) newCustomer(rng *rand.Rand, now time.Time) (Customer, bool) {
+	names := d.Profile.Names
+	if len(names) == 0 {
+		names = defaultDemand.Names
+	}
+	customer := Customer{
+		Name:        names[rng.Intn(len(names))],
+		RequestedAt: now,
+	}
+
+	// Customers stand somewhere along a block, not necessarily at a corner
+	from, to := d.pickZone(rng, now.Hour(), true), d.pickZone(rng, now.Hour(), false)
+	var pickup, dropoff EdgeSnap
+	for i := 0; i < 6; i++ {
+		pickup, _ = d.roadPoint(rng, from)
+		dropoff, _ = d.roadPoint(rng, to)
+		if len(routeBetween(d.g, routeCost, pickup, dropoff)) > 0 {
+			customer.Lat, customer.Lon = pickup.Lat, pickup.Lon
+			customer.DestinationLat, customer.DestinationLon = dropoff.Lat, dropoff.Lon
+			return customer, true
+		}
+		if i == 0 {
+			fmt.Printf("⚠️ Customer %s could not find path to random start/end node\n", customer.Name)
+		}
+	}
+	fmt.Printf("⚠️ Customer %s could not find path to random start/end node after five tries\n", customer.Name)
+	return customer, false
+}
+
+// spawnArrivals enqueues every customer the demand profile has due by now
+// and returns how many it enqueued and when the next is due, or the zero
+// time if none ever will be. Callers must hold driverMu.
+func (s *Simulation) spawnArrivals(now time.Time) (int, time.Time) {
+	if s.nextArrival.IsZero() {
+		s.nextArrival = s.Demand.nextArrival(s.Rand, now)
+	}
+	n := 0
+	for !s.nextArrival.After(now) {
+		if customer, ok := s.Demand.newCustomer(s.Rand, s.nextArrival); ok {
+			s.enqueue(customer)
+			n++
+		}
+		s.nextArrival = s.Demand.nextArrival(s.Rand, s.nextArrival)
+	}
+	if s.nextArrival.Equal(never) {
+		return n, time.Time{}
+	}
+	return n, s.nextArrival
+}
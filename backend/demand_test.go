@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestArrivalsFollowLocalHours(t *testing.T) {
+	// Customers only arrive from 11:00 to 12:00 local time, in a zone whose
+	// hours start at :30 past the UTC hour
+	rates := make([]float64, 24)
+	rates[11] = 120
+	d, err := newDemand(&DemandProfile{HourlyRates: rates}, testGrid(2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	india := time.FixedZone("IST", 5*3600+1800)
+	start := time.Date(2026, time.March, 2, 10, 0, 0, 0, india)
+	rng := rand.New(rand.NewSource(3))
+
+	early := 0
+	at := start
+	for {
+		at = d.nextArrival(rng, at)
+		if at.Sub(start) > 2*time.Hour {
+			break
+		}
+		if at.Hour() != 11 {
+			t.Fatalf("arrival at %v, outside the 11:00 hour", at)
+		}
+		if at.Minute() < 30 {
+			early++
+		}
+	}
+	if early == 0 {
+		t.Error("no arrivals between 11:00 and 11:30")
+	}
+	if next := start.Add(24 * time.Hour); at.Hour() != 11 || at.YearDay() != next.YearDay() {
+		t.Errorf("after the busy hour the next arrival is at %v, want the next day's 11:00 hour", at)
+	}
+}
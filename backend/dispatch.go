@@ -0,0 +1,150 @@
+package main
+
+import "time"
+
+// dispatchInterval is how often the dispatcher retries customers that are
+// still waiting, e.g. because every driver was busy.
+const dispatchInterval = time.Second
+
+// notifyDispatcher lets enqueue and drop-offs nudge the dispatcher instead
+// of waiting out the interval.
+func (s *Simulation) notifyDispatcher() {
+	select {
+	case s.wake <- struct{}{}:
+	default: // a wake-up is already pending
+	}
+}
+
+// runDispatcher is the only thing that assigns customers to drivers. It runs
+// next to moveDrivers and takes driverMu only to snapshot the fleet and to
+// apply a pass's assignments, so drivers keep moving while it routes.
+func (s *Simulation) runDispatcher() {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+		s.dispatchQueued(s.Clock.Now())
+	}
+}
+
+// dispatchPlan is the state one dispatch pass matched against.
+type dispatchPlan struct {
+	drivers []Driver
+	waiting []Customer
+}
+
+// dispatchQueued drops customers who have run out of patience, asks the
+// simulation's Dispatcher to pair the rest with available drivers, applies
+// its assignments and returns how many were made. Matching works on a
+// snapshot without holding driverMu; callers must not hold it.
+func (s *Simulation) dispatchQueued(now time.Time) int {
+	s.driverMu.Lock()
+	plan := s.planDispatch(now)
+	s.driverMu.Unlock()
+	if len(plan.waiting) == 0 {
+		return 0
+	}
+
+	assignments := s.Dispatcher.Match(s.matchEnv(), plan.drivers, plan.waiting)
+
+	s.driverMu.Lock()
+	defer s.driverMu.Unlock()
+	return s.applyDispatch(plan, assignments, now)
+}
+
+// planDispatch expires impatient customers and copies the fleet and queue for
+// matching. Stop lists are copied on write (see setRiderStatus), so sharing
+// them with the copy is safe. Callers must hold driverMu.
+func (s *Simulation) planDispatch(now time.Time) dispatchPlan {
+	s.expireWaiting(now)
+	waiting := s.queueSnapshot()
+	if len(waiting) == 0 {
+		return dispatchPlan{}
+	}
+	return dispatchPlan{drivers: append([]Driver(nil), s.Drivers...), waiting: waiting}
+}
+
+// applyDispatch makes the assignments that still hold. One is dropped if its
+// customer has left the queue, or its driver has moved or changed its stops
+// since the snapshot, since its path and stops were planned from there; the
+// customer stays queued for the next pass. Callers must hold driverMu.
+func (s *Simulation) applyDispatch(plan dispatchPlan, assignments []Assignment, now time.Time) int {
+	// The stops each driver had when the dispatcher planned its next
+	// assignment; a pooling driver can take several in one pass
+	planned := make(map[int][]Stop, len(assignments))
+	made, stale := 0, 0
+	for _, a := range assignments {
+		before, ok := planned[a.Driver]
+		if !ok {
+			before = plan.drivers[a.Driver].Stops
+		}
+		planned[a.Driver] = a.Stops
+		i, ok := s.stillMatches(plan.drivers[a.Driver], before, a.Customer.Id)
+		if !ok {
+			stale++
+			continue
+		}
+
+		driver := &s.Drivers[i]
+		customer := s.assignDriver(driver, a.Customer, a.Stops, a.Path, now)
+		s.removeFromQueue(customer.Id)
+		s.openTrips.assigned(driver.Name, customer, a.ETA, now)
+		s.Events.Publish(LiveEvent{Type: EventCustomerAssigned, Time: now, Driver: driver.Name, Data: customer})
+		made++
+	}
+	if stale > 0 {
+		s.notifyDispatcher()
+	}
+	return made
+}
+
+// stillMatches finds the driver planned from and reports whether it is still
+// where it was, with the stops the plan expects, and the customer is still
+// waiting. Callers must hold driverMu.
+func (s *Simulation) stillMatches(was Driver, stops []Stop, customerID int) (int, bool) {
+	i := indexOfDriver(s.Drivers, was.Name)
+	if i < 0 {
+		return -1, false
+	}
+	now := &s.Drivers[i]
+	if now.Lat != was.Lat || now.Lon != was.Lon || !sameStops(now.Stops, stops) {
+		return -1, false
+	}
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	for _, c := range s.Queue {
+		if c.Id == customerID {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func indexOfDriver(drivers []Driver, name string) int {
+	for i := range drivers {
+		if drivers[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameStops compares stop lists by kind and customer, ignoring the statuses
+// riders have moved through since.
+func sameStops(a, b []Stop) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Kind != b[i].Kind || a[i].Customer.Id != b[i].Customer.Id {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package main
+
+import "fmt"
+
+// Dispatcher decides which idle drivers take which waiting customers.
+// Implementations only propose assignments, from a copy of the fleet and
+// without holding driverMu; dispatchQueued applies the ones that still hold.
+type Dispatcher interface {
+	Name() string
+	Match(env MatchEnv, drivers []Driver, waiting []Customer) []Assignment
+}
+
+// Assignment gives Customer to Driver (an index into the ranked driver
+// slice). Stops is the driver's full new stop list and Path leads to its
+// first stop.
+type Assignment struct {
+	Driver   int
+	Customer Customer
+	Stops    []Stop
+	Path     []GraphNode
+	ETA      float64 // minutes to the customer's pickup
+}
+
+func newDispatcher(name string) (Dispatcher, error) {
+	switch name {
+	case "greedy":
+		return GreedyDispatcher{}, nil
+	case "hungarian":
+		return HungarianDispatcher{}, nil
+	}
+	return nil, fmt.Errorf("unknown dispatcher %q (want greedy or hungarian)", name)
+}
+
+// GreedyDispatcher serves customers oldest first, each taking the driver with
+// the best pickup ETA at that moment. A pooling driver can take several
+// customers in one pass.
+type GreedyDispatcher struct{}
+
+func (GreedyDispatcher) Name() string { return "greedy" }
+
+func (GreedyDispatcher) Match(env MatchEnv, drivers []Driver, waiting []Customer) []Assignment {
+	drivers = append([]Driver(nil), drivers...) // mark drivers taken on a copy
+	var out []Assignment
+	for _, customer := range waiting {
+		ranked := rankDrivers(env, drivers, customer, matchCandidates)
+		if len(ranked) == 0 {
+			continue // nobody idle can reach this one; later customers may still match
+		}
+		best := ranked[0]
+		drivers[best.Index].Stops = best.Stops
+		out = append(out, Assignment{Driver: best.Index, Customer: customer, Stops: best.Stops, Path: best.Path, ETA: best.ETA})
+	}
+	return out
+}
+
+// HungarianDispatcher assigns the whole queue at once, minimising the summed
+// ETA over every driver/customer pair it makes. Each driver gets at most one
+// new customer per pass, even with free seats.
+type HungarianDispatcher struct{}
+
+func (HungarianDispatcher) Name() string { return "hungarian" }
+
+// unreachable stands in for pairs that weren't routed. It must stay finite
+// so the solver's potentials don't turn into NaN.
+const unreachable = 1e9
+
+func (HungarianDispatcher) Match(env MatchEnv, drivers []Driver, waiting []Customer) []Assignment {
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	// Each customer only routes its nearest few drivers; every other pair is
+	// priced as unreachable. Batches look a bit wider than greedy so a
+	// crowded area can still borrow a driver from further out.
+	candidates := make([]map[int]DriverCandidate, len(waiting))
+	var idle []int
+	seen := map[int]bool{}
+	for c, customer := range waiting {
+		candidates[c] = map[int]DriverCandidate{}
+		for _, cand := range rankDrivers(env, drivers, customer, 2*matchCandidates) {
+			candidates[c][cand.Index] = cand
+			if !seen[cand.Index] {
+				seen[cand.Index] = true
+				idle = append(idle, cand.Index)
+			}
+		}
+	}
+	if len(idle) == 0 {
+		return nil
+	}
+
+	// The solver wants rows <= columns, so put the smaller side on the rows
+	customersOnRows := len(waiting) <= len(idle)
+	rows, cols := len(idle), len(waiting)
+	if customersOnRows {
+		rows, cols = len(waiting), len(idle)
+	}
+	cost := make([][]float64, rows)
+	for r := range cost {
+		cost[r] = make([]float64, cols)
+		for col := range cost[r] {
+			c, d := col, r
+			if customersOnRows {
+				c, d = r, col
+			}
+			cost[r][col] = unreachable
+			if cand, ok := candidates[c][idle[d]]; ok {
+				cost[r][col] = cand.ETA
+			}
+		}
+	}
+
+	var out []Assignment
+	for r, col := range hungarian(cost) {
+		if cost[r][col] >= unreachable {
+			continue
+		}
+		c, d := col, r
+		if customersOnRows {
+			c, d = r, col
+		}
+		cand := candidates[c][idle[d]]
+		out = append(out, Assignment{Driver: cand.Index, Customer: waiting[c], Stops: cand.Stops, Path: cand.Path, ETA: cand.ETA})
+	}
+	return out
+}
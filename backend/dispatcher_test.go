@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestHungarianMatch(t *testing.T) {
+	g := testGrid(3, 20)
+	env := MatchEnv{Graph: g, MaxDetour: defaultMaxDetour}
+	node := func(r, c int) int32 {
+		n, _ := g.Lookup(strconv.Itoa(r*20 + c + 1))
+		return n
+	}
+	driverAt := func(name string, at int32) Driver {
+		return Driver{Name: name, Lat: g.Lat.At(at), Lon: g.Lon.At(at), Capacity: 1}
+	}
+
+	// Customers only route their 2*matchCandidates nearest drivers. Here
+	// eleven customers in the east share the same ten, so one of them is
+	// left with nothing but unreachable pairs, while the two drivers in
+	// the west are only candidates for the one customer there.
+	var crowdDrivers []Driver
+	var crowd []Customer
+	for i := 0; i < 10; i++ {
+		crowdDrivers = append(crowdDrivers, driverAt(strconv.Itoa(i), node(i%2, 14+i/2)))
+	}
+	crowdDrivers = append(crowdDrivers, driverAt("west 1", node(0, 0)), driverAt("west 2", node(1, 0)))
+	for i := 0; i < 11; i++ {
+		crowd = append(crowd, gridRider(g, i+1, node(i%3, 13+i%7), node(2, 19-i%5)))
+	}
+	crowd = append(crowd, gridRider(g, 12, node(0, 1), node(2, 3)))
+	busy := driverAt("busy", node(1, 1))
+	busy.HasCustomer = true
+
+	tests := []struct {
+		name               string
+		drivers, customers int // placed at random, or
+		fleet              []Driver
+		waiting            []Customer
+		want               int // assignments
+	}{
+		{name: "more drivers than customers", drivers: 7, customers: 3, want: 3},
+		{name: "fewer drivers than customers", drivers: 3, customers: 7, want: 3},
+		{name: "as many", drivers: 4, customers: 4, want: 4},
+		{name: "a customer with no candidate left", fleet: crowdDrivers, waiting: crowd, want: 11},
+		{name: "no driver free", fleet: []Driver{busy}, customers: 2, want: 0},
+	}
+	for _, tt := range tests {
+		for seed := int64(1); seed <= 5; seed++ {
+			rng := rand.New(rand.NewSource(seed))
+			drivers, waiting := append([]Driver(nil), tt.fleet...), append([]Customer(nil), tt.waiting...)
+			for i := 0; i < tt.drivers; i++ {
+				drivers = append(drivers, driverAt(strconv.Itoa(i), node(rng.Intn(3), rng.Intn(8))))
+			}
+			for i := 0; i < tt.customers; i++ {
+				waiting = append(waiting, gridRider(g, i+1, node(rng.Intn(3), rng.Intn(8)), node(rng.Intn(3), rng.Intn(8))))
+			}
+
+			got := HungarianDispatcher{}.Match(env, drivers, waiting)
+			if len(got) != tt.want {
+				t.Errorf("%s, seed %d: %d assignments, want %d", tt.name, seed, len(got), tt.want)
+			}
+			driverSeen, customerSeen := map[int]bool{}, map[int]bool{}
+			total := 0.0
+			for _, a := range got {
+				if driverSeen[a.Driver] {
+					t.Errorf("%s, seed %d: driver %d assigned twice", tt.name, seed, a.Driver)
+				}
+				if customerSeen[a.Customer.Id] {
+					t.Errorf("%s, seed %d: customer %d assigned twice", tt.name, seed, a.Customer.Id)
+				}
+				driverSeen[a.Driver], customerSeen[a.Customer.Id] = true, true
+				if a.ETA >= unreachable {
+					t.Errorf("%s, seed %d: customer %d assigned an unreachable driver", tt.name, seed, a.Customer.Id)
+				}
+				if len(a.Stops) == 0 || a.Stops[0].Customer.Id != a.Customer.Id {
+					t.Errorf("%s, seed %d: driver %d isn't sent to customer %d", tt.name, seed, a.Driver, a.Customer.Id)
+				}
+				total += a.ETA
+			}
+
+			greedy := GreedyDispatcher{}.Match(env, drivers, waiting)
+			greedyTotal := 0.0
+			for _, a := range greedy {
+				greedyTotal += a.ETA
+			}
+			// Greedy goes past the nearest drivers once they're taken, so
+			// in the crowd it serves one more customer; sums of different
+			// numbers of ETAs don't compare
+			if len(greedy) == len(got) && total > greedyTotal+1e-9 {
+				t.Errorf("%s, seed %d: total ETA %.3f is worse than greedy's %.3f", tt.name, seed, total, greedyTotal)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+)
+
+// snappedNodeID marks a path point that lies part-way along an edge rather
+// than on a graph node. Its EdgeFrom/EdgeTo name the edge it sits on.
+const snappedNodeID = -1
+
+// EdgeSnap is a point projected onto the directed edge From -> To.
+type EdgeSnap struct {
+	Edge int32
+	From int32
+	To   int32
+	T    float64 // fraction of the edge between From and the point, 0..1
+	Lat  float64
+	Lon  float64
+	Dist float64 // meters from the query point to the projected point
+}
+
+// snapToEdge projects (lat, lon) onto edge e. The projection is flat
+// (equirectangular around the query), which is plenty at street scale.
+func (g *RoadGraph) snapToEdge(e int32, lat, lon float64) EdgeSnap {
+	from, to := g.edgeFrom(e), g.EdgeTo[e]
+	k := math.Cos(lat * math.Pi / 180)
+	ax, ay := (g.Lon.At(from)-lon)*k, g.Lat.At(from)-lat
+	dx, dy := (g.Lon.At(to)-g.Lon.At(from))*k, g.Lat.At(to)-g.Lat.At(from)
+
+	t := 0.0
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l2))
+	}
+	snap := EdgeSnap{
+		Edge: e,
+		From: from,
+		To:   to,
+		T:    t,
+		Lat:  g.Lat.At(from) + t*(g.Lat.At(to)-g.Lat.At(from)),
+		Lon:  g.Lon.At(from) + t*(g.Lon.At(to)-g.Lon.At(from)),
+	}
+	snap.Dist = haversine(lat, lon, snap.Lat, snap.Lon)
+	return snap
+}
+
+// edgeFrom finds the source node of edge e by binary search over EdgeStart.
+func (g *RoadGraph) edgeFrom(e int32) int32 {
+	lo, hi := 0, g.NumNodes()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if g.EdgeStart[mid+1] <= e {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return int32(lo)
+}
+
+// FindEdge returns the edge from -> to, if the graph has one.
+func (g *RoadGraph) FindEdge(from, to int32) (int32, bool) {
+	lo, hi := g.Edges(from)
+	for e := lo; e < hi; e++ {
+		if g.EdgeTo[e] == to {
+			return e, true
+		}
+	}
+	return -1, false
+}
+
+// point turns a snap into a path entry. Snaps that land exactly on a node
+// are returned as that node.
+func (g *RoadGraph) point(s EdgeSnap) GraphNode {
+	if s.T == 0 {
+		return g.Node(s.From)
+	}
+	if s.T == 1 {
+		return g.Node(s.To)
+	}
+	return GraphNode{
+		ID:       snappedNodeID,
+		Lat:      s.Lat,
+		Lon:      s.Lon,
+		EdgeFrom: g.NodeID[s.From],
+		EdgeTo:   g.NodeID[s.To],
+	}
+}
+
+// segmentEdge finds the edge a path step a -> b travels along, including
+// steps that start or end part-way along an edge.
+func (g *RoadGraph) segmentEdge(a, b GraphNode) (int32, bool) {
+	from, to := a.ID, b.ID
+	switch {
+	case a.ID == snappedNodeID && b.ID == snappedNodeID:
+		// Both ends on the same edge; try it forwards, then backwards
+		if e, ok := g.findEdgeByID(a.EdgeFrom, a.EdgeTo); ok {
+			return e, true
+		}
+		from, to = a.EdgeTo, a.EdgeFrom
+	case a.ID == snappedNodeID:
+		from = a.EdgeFrom
+		if from == b.ID {
+			from = a.EdgeTo
+		}
+	case b.ID == snappedNodeID:
+		to = b.EdgeTo
+		if to == a.ID {
+			to = b.EdgeFrom
+		}
+	}
+	return g.findEdgeByID(from, to)
+}
+
+func (g *RoadGraph) findEdgeByID(fromID, toID int) (int32, bool) {
+	from, ok := g.lookupID(fromID)
+	if !ok {
+		return -1, false
+	}
+	to, ok := g.lookupID(toID)
+	if !ok {
+		return -1, false
+	}
+	return g.FindEdge(from, to)
+}
+
+// snapEntry is one way onto or off the road network from a snapped point:
+// the edge driven part-way, the node at its far end (exits) or start
+// (entries), and the cost of the part driven.
+type snapEntry struct {
+	edge int32
+	node int32
+	cost float64
+}
+
+// exits lists the ways a vehicle at s can drive off to a node directly.
+func (g *RoadGraph) exits(model CostModel, s EdgeSnap) []snapEntry {
+	out := []snapEntry{{s.Edge, s.To, (1 - s.T) * model.EdgeCost(g, s.Edge)}}
+	if rev, ok := g.FindEdge(s.To, s.From); ok {
+		out = append(out, snapEntry{rev, s.From, s.T * model.EdgeCost(g, rev)})
+	}
+	return out
+}
+
+// entries lists the ways a vehicle can drive from a node directly to s.
+func (g *RoadGraph) entries(model CostModel, s EdgeSnap) []snapEntry {
+	out := []snapEntry{{s.Edge, s.From, s.T * model.EdgeCost(g, s.Edge)}}
+	if rev, ok := g.FindEdge(s.To, s.From); ok {
+		out = append(out, snapEntry{rev, s.To, (1 - s.T) * model.EdgeCost(g, rev)})
+	}
+	return out
+}
+
+// directCost is the cost of driving from src to dst without leaving their
+// shared road segment, or +Inf when they aren't on one or its direction
+// forbids it.
+func (g *RoadGraph) directCost(model CostModel, src, dst EdgeSnap) float64 {
+	var at float64 // dst's position measured along src's edge
+	switch {
+	case dst.From == src.From && dst.To == src.To:
+		at = dst.T
+	case dst.From == src.To && dst.To == src.From:
+		at = 1 - dst.T
+	default:
+		return math.Inf(1)
+	}
+	if at >= src.T {
+		return (at - src.T) * model.EdgeCost(g, src.Edge)
+	}
+	if rev, ok := g.FindEdge(src.To, src.From); ok {
+		return (src.T - at) * model.EdgeCost(g, rev)
+	}
+	return math.Inf(1)
+}
+
+// routeBetween runs A* from one snapped point to another. The path starts and
+// ends at the snapped points, so pickups and drop-offs can happen mid-block.
+func routeBetween(g *RoadGraph, model CostModel, src, dst EdgeSnap) []GraphNode {
+	if src.Edge < 0 || dst.Edge < 0 {
+		return nil
+	}
+	best := g.directCost(model, src, dst)
+	if !math.IsInf(best, 1) {
+		return []GraphNode{g.point(src), g.point(dst)}
+	}
+	if ch := g.CH; ch != nil && ch.Model == model {
+		states, _ := ch.route(ch.snapEnds(g, model, src, dst))
+		if states == nil {
+			return nil
+		}
+		return g.snappedPath(src, dst, statePath(g, states))
+	}
+
+	s := acquireSearch(g)
+	defer searchPool.Put(s)
+
+	h := func(n int32) float64 {
+		return model.LowerBound(g, g.straightLine(n, dst.Lat, dst.Lon))
+	}
+	for _, exit := range g.exits(model, src) {
+		s.visit(exit.edge, -1, exit.cost)
+		heap.Push(&s.open, openItem{edge: exit.edge, f: exit.cost + s.estimate(exit.node, h)})
+	}
+	arrive := g.entries(model, dst)
+
+	bestEdge := int32(-1)
+	for s.open.Len() > 0 {
+		item := heap.Pop(&s.open).(openItem)
+		if item.f >= best {
+			break
+		}
+		current := item.edge
+		if s.closed[current] == s.stamp {
+			continue
+		}
+		s.closed[current] = s.stamp
+
+		for _, entry := range arrive {
+			if entry.node != g.EdgeTo[current] {
+				continue
+			}
+			// A destination right on the node needs no turn onto its edge
+			seconds, ok := 0.0, true
+			if entry.cost > 0 {
+				seconds, ok = g.Turn(current, entry.edge)
+			}
+			if ok {
+				if cost := s.g[current] + model.TurnCost(g, seconds) + entry.cost; cost < best {
+					best, bestEdge = cost, current
+				}
+			}
+		}
+		s.relax(g, model, current, h)
+	}
+	if bestEdge < 0 {
+		return nil
+	}
+
+	return g.snappedPath(src, dst, reconstructPath(g, s.parent, bestEdge))
+}
+
+// snappedPath puts the snapped points at either end of a route's nodes,
+// unless they are the end nodes themselves.
+func (g *RoadGraph) snappedPath(src, dst EdgeSnap, nodes []GraphNode) []GraphNode {
+	path := make([]GraphNode, 0, len(nodes)+2)
+	if start := g.point(src); start.ID == snappedNodeID || start.ID != nodes[0].ID {
+		path = append(path, start)
+	}
+	path = append(path, nodes...)
+	if end := g.point(dst); end.ID == snappedNodeID || end.ID != nodes[len(nodes)-1].ID {
+		path = append(path, end)
+	}
+	return path
+}
@@ -1,20 +1,28 @@
 package main
 
-import "strconv"
-
-func estimateETA(path []GraphNode) float64 {
+func estimateETA(g *RoadGraph, path []GraphNode) float64 {
 	totalSeconds := 0.0
+	prev := int32(-1) // the edge driven before this step
 	for i := 1; i < len(path); i++ {
 		from := path[i-1]
 		to := path[i]
 
-		edge, ok := graph[strconv.Itoa(from.ID)].Neighbors[strconv.Itoa(to.ID)]
-		if !ok || edge.Speed == 0 {
+		// Snapped end points make the first and last steps partial edges;
+		// the straight-line distance covers just the part actually driven
+		edge, ok := g.segmentEdge(from, to)
+		if !ok {
+			prev = -1
 			continue
 		}
+		if prev >= 0 && from.ID != snappedNodeID {
+			seconds, _ := g.Turn(prev, edge)
+			totalSeconds += seconds
+		}
+		prev = edge
 
+		// Untagged roads are driven at the default speed, as routing prices them
 		distance := haversine(from.Lat, from.Lon, to.Lat, to.Lon)
-		seconds := (distance / (edge.Speed * 1000)) * 3600
+		seconds := (distance / (g.Speed(edge) * 1000)) * 3600
 		totalSeconds += seconds
 
 		// Add realistic delay estimates
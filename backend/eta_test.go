@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateETA(t *testing.T) {
+	g := testGrid(2, 2)
+	// 1 -> 0 is a 50 km/h street into a light, 2 -> 3 an untagged one
+	e10, _ := g.FindEdge(1, 0)
+	e23, _ := g.FindEdge(2, 3)
+	tests := []struct {
+		name string
+		path []GraphNode
+		want float64 // seconds
+	}{
+		{"into a light", []GraphNode{g.Node(1), g.Node(0)}, g.EdgeDist.At(e10)/(50/3.6) + 25*.3},
+		{"untagged street", []GraphNode{g.Node(2), g.Node(3)}, g.EdgeDist.At(e23) / (defaultSpeed / 3.6)},
+	}
+	for _, tt := range tests {
+		if got := estimateETA(g, tt.path); math.Abs(got-tt.want/60) > 1e-9 {
+			t.Errorf("%s: ETA %v min, want %v", tt.name, got, tt.want/60)
+		}
+	}
+}
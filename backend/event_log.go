@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// An event log is JSON lines: a header describing the run, then every event
+// the simulation published, in order. Restarting the server with the same
+// log appends a new header and carries on, so a file may hold several
+// segments.
+const (
+	eventLogFormat  = "ridesync-events"
+	eventLogVersion = 2 // 2 logs routeChanged as a RouteChange
+
+	// eventLogFlushEvery bounds how much a crash can lose.
+	eventLogFlushEvery = time.Second
+	// eventLogBuffer is how many events may wait for the writer before
+	// Record blocks.
+	eventLogBuffer = 4096
+)
+
+// EventLogHeader starts each segment of an event log.
+type EventLogHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	Source     string    `json:"source"`     // "sim" or "server"; only sim logs can be verified
+	RecordedAt time.Time `json:"recordedAt"` // wall clock
+	GraphNodes int       `json:"graphNodes"`
+	GraphEdges int       `json:"graphEdges"`
+	// For the server, Run.Config is the flags it started with and Run.Start
+	// the simulated time then; a restored snapshot may have moved both on.
+	Run SimRun `json:"run"`
+}
+
+// loggedEvent is one line after the header.
+type loggedEvent struct {
+	Seq uint64 `json:"seq"` // from 1 in each segment
+	LiveEvent
+}
+
+// RouteChange is what a log keeps of a routeChanged event: the new route,
+// once, and the stops it serves. Replay rebuilds the rest of the driver from
+// the events before it.
+type RouteChange struct {
+	Path         []GraphNode `json:"path"`
+	Stops        []Stop      `json:"stops"`
+	LegStartedAt time.Time   `json:"legStartedAt"`
+	MoveTime     time.Time   `json:"moveTime"`
+}
+
+// logForm is ev as a log records it.
+func logForm(ev LiveEvent) LiveEvent {
+	if driver, ok := ev.Data.(Driver); ok && ev.Type == EventRouteChanged {
+		ev.Data = RouteChange{Path: driver.GraphPath, Stops: driver.Stops, LegStartedAt: driver.LegStartedAt, MoveTime: driver.MoveTime}
+	}
+	return ev
+}
+
+// EventLog appends events to a file. Record only queues them; a goroutine
+// encodes and writes them, so publishing never waits on the disk unless the
+// writer falls eventLogBuffer events behind.
+type EventLog struct {
+	mu     sync.Mutex // guards closed and sending on events
+	closed bool
+	events chan LiveEvent
+	done   chan struct{} // closed when the writer has finished
+
+	// Only the writer touches these once it has started
+	file *os.File
+	w    *bufio.Writer
+	seq  uint64
+	err  error
+}
+
+// createEventLog opens path for appending, creating it if need be, and
+// starts a new segment with header.
+func createEventLog(path string, header EventLogHeader, g *RoadGraph) (*EventLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening event log: %w", err)
+	}
+	l := &EventLog{
+		file:   file,
+		w:      bufio.NewWriter(file),
+		events: make(chan LiveEvent, eventLogBuffer),
+		done:   make(chan struct{}),
+	}
+
+	header.Format = eventLogFormat
+	header.Version = eventLogVersion
+	header.RecordedAt = time.Now()
+	header.GraphNodes = g.NumNodes()
+	header.GraphEdges = len(g.EdgeTo)
+	if err := l.writeLine(header); err == nil {
+		err = l.w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("writing event log header: %w", err)
+	}
+	go l.write()
+	return l, nil
+}
+
+func (l *EventLog) writeLine(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
+
+// Record queues ev to be appended.
+func (l *EventLog) Record(ev LiveEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.events <- logForm(ev)
+	}
+}
+
+// write appends queued events until Close, flushing at least every
+// eventLogFlushEvery. The first error is logged and stops recording; the
+// simulation carries on without it.
+func (l *EventLog) write() {
+	defer close(l.done)
+	flush := time.NewTicker(eventLogFlushEvery)
+	defer flush.Stop()
+	for {
+		select {
+		case ev, ok := <-l.events:
+			if !ok {
+				return
+			}
+			if l.err != nil {
+				continue
+			}
+			l.seq++
+			if l.err = l.writeLine(loggedEvent{Seq: l.seq, LiveEvent: ev}); l.err != nil {
+				log.Printf("❌ Event log stopped: %v\n", l.err)
+			}
+		case <-flush.C:
+			if l.err == nil {
+				if l.err = l.w.Flush(); l.err != nil {
+					log.Printf("❌ Event log stopped: %v\n", l.err)
+				}
+			}
+		}
+	}
+}
+
+// Close writes out every queued event and closes the file. It returns the
+// first error recording hit.
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.events)
+	l.mu.Unlock()
+
+	<-l.done
+	err := l.err
+	if err == nil {
+		err = l.w.Flush()
+	}
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recordSnapshot logs the whole fleet and queue without publishing it, so a
+// log can be replayed from there. Subscribers already get their own.
+func (s *Simulation) recordSnapshot() {
+	s.driverMu.Lock()
+	defer s.driverMu.Unlock()
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	s.Events.Record(LiveEvent{Type: EventSnapshot, Time: s.Clock.Now(), Data: LiveSnapshot{
+		Drivers: append([]Driver(nil), s.Drivers...),
+		CustQue: append([]Customer(nil), s.Queue...),
+	}})
+}
+
+// RecordedEvent is an event read back from a log, its data still raw.
+type RecordedEvent struct {
+	Seq    uint64          `json:"seq"`
+	Type   string          `json:"type"`
+	Time   time.Time       `json:"time"`
+	Driver string          `json:"driver"`
+	Data   json.RawMessage `json:"data"`
+	Line   int             `json:"-"`
+}
+
+// EventLogSegment is one header and the events recorded after it.
+type EventLogSegment struct {
+	Header EventLogHeader
+	Events []RecordedEvent
+}
+
+// readEventLog reads every segment of the log at path. A last line cut
+// short by a crash is skipped with a warning; a bad line anywhere else is
+// an error.
+func readEventLog(path string) ([]EventLogSegment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // snapshots and routes carry whole paths
+	var segments []EventLogSegment
+	var broken error
+	line := 0
+	for scanner.Scan() {
+		line++
+		if broken != nil {
+			return nil, broken
+		}
+		text := scanner.Bytes()
+		if len(text) == 0 {
+			continue
+		}
+
+		var probe struct {
+			Format string `json:"format"`
+		}
+		if err := json.Unmarshal(text, &probe); err != nil {
+			broken = fmt.Errorf("event log %s line %d: %w", path, line, err)
+			continue
+		}
+		if probe.Format != "" {
+			var header EventLogHeader
+			if err := json.Unmarshal(text, &header); err != nil {
+				return nil, fmt.Errorf("event log %s line %d: %w", path, line, err)
+			}
+			if header.Format != eventLogFormat || header.Version != eventLogVersion {
+				return nil, fmt.Errorf("event log %s line %d: format %s version %d, this build reads %s version %d",
+					path, line, header.Format, header.Version, eventLogFormat, eventLogVersion)
+			}
+			segments = append(segments, EventLogSegment{Header: header})
+			continue
+		}
+		if len(segments) == 0 {
+			return nil, fmt.Errorf("event log %s line %d: event before any header", path, line)
+		}
+		var ev RecordedEvent
+		if err := json.Unmarshal(text, &ev); err != nil {
+			broken = fmt.Errorf("event log %s line %d: %w", path, line, err)
+			continue
+		}
+		ev.Line = line
+		seg := &segments[len(segments)-1]
+		seg.Events = append(seg.Events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading event log %s: %w", path, err)
+	}
+	if broken != nil {
+		log.Printf("⚠️ Skipping the last line, the log was cut short: %v\n", broken)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("event log %s has no header", path)
+	}
+	return segments, nil
+}
+
+// Event decodes e's data into the type its publisher used.
+func (e RecordedEvent) Event() (LiveEvent, error) {
+	ev := LiveEvent{Type: e.Type, Time: e.Time, Driver: e.Driver}
+	var err error
+	switch e.Type {
+	case EventSnapshot:
+		var data LiveSnapshot
+		err = json.Unmarshal(e.Data, &data)
+		ev.Data = data
+	case EventDriverMoved:
+		var data DriverMove
+		err = json.Unmarshal(e.Data, &data)
+		ev.Data = data
+	case EventRouteChanged:
+		var data RouteChange
+		err = json.Unmarshal(e.Data, &data)
+		ev.Data = data
+	case EventETAChanged:
+		var data float64
+		err = json.Unmarshal(e.Data, &data)
+		ev.Data = data
+	case EventCustomerEnqueued, EventCustomerAssigned, EventPickup, EventDropoff, EventCustomerCancelled, EventCustomerExpired:
+		var data Customer
+		err = json.Unmarshal(e.Data, &data)
+		ev.Data = data
+	default:
+		return ev, fmt.Errorf("line %d: unknown event type %q", e.Line, e.Type)
+	}
+	if err != nil {
+		return ev, fmt.Errorf("line %d: %s data: %w", e.Line, e.Type, err)
+	}
+	return ev, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventLogRecordsAndVerifies(t *testing.T) {
+	g := testGrid(8, 8)
+	run := SimRun{
+		Config:   SimConfig{Seed: 5, Dispatcher: "greedy", Capacity: 2, MaxDetour: defaultMaxDetour, Drivers: 3, Demand: uniformDemand(60)},
+		Start:    testStart,
+		Duration: 30 * time.Minute,
+	}
+	path := filepath.Join(t.TempDir(), "run.jsonl")
+	eventLog, err := createEventLog(path, EventLogHeader{Source: "sim", Run: run}, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run.execute(g, nil, eventLog); err != nil {
+		t.Fatal(err)
+	}
+	if err := eventLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	eventLog.Record(LiveEvent{Type: EventETAChanged, Time: testStart, Data: 1.0}) // ignored once closed
+
+	segments, err := readEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := segments[0].Events
+	routes := 0
+	for i, e := range events {
+		if e.Seq != uint64(i+1) {
+			t.Fatalf("event %d has seq %d", i, e.Seq)
+		}
+		if e.Type != EventRouteChanged {
+			continue
+		}
+		routes++
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(e.Data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, whole := fields["name"]; whole {
+			t.Fatalf("line %d logs the whole driver", e.Line)
+		}
+		ev, err := e.Event()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change := ev.Data.(RouteChange); len(change.Path) == 0 {
+			t.Errorf("line %d: route change without a path", e.Line)
+		}
+	}
+	if routes == 0 || events[0].Type != EventSnapshot {
+		t.Fatalf("log of %d events starts with %s and has %d route changes", len(events), events[0].Type, routes)
+	}
+
+	// The same run matches the log; another seed doesn't
+	v := &logVerifier{events: events}
+	if _, err := run.execute(g, nil, v); err != nil {
+		t.Fatal(err)
+	}
+	if v.divergence != "" || v.next != len(events) {
+		t.Errorf("rerun diverged after %d of %d events: %s", v.next, len(events), v.divergence)
+	}
+	run.Config.Seed++
+	v = &logVerifier{events: events}
+	if _, err := run.execute(g, nil, v); err != nil {
+		t.Fatal(err)
+	}
+	if v.divergence == "" {
+		t.Error("a run with another seed matched the log")
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"net/http"
 )
 
-func getDrivers(w http.ResponseWriter, r *http.Request) {
+func getDrivers(sim *Simulation, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -15,10 +15,10 @@ func getDrivers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
-	driverMutex.Lock()
-	defer driverMutex.Unlock()
+	sim.driverMu.Lock()
+	defer sim.driverMu.Unlock()
 
-	err := json.NewEncoder(w).Encode(driverList)
+	err := json.NewEncoder(w).Encode(sim.Drivers)
 	if err != nil {
 		log.Printf("❌ Failed to encode driver list: %v\n", err)
 	}
@@ -0,0 +1,49 @@
+package main
+
+import "strconv"
+
+// testGridNodes lays out a rows×cols grid of two-way streets about 110 m
+// apart. Rows alternate between 50 km/h and untagged (40 km/h) streets,
+// columns are 30 km/h, and every third junction has a traffic light.
+func testGridNodes(rows, cols int) map[string]GraphNode {
+	id := func(r, c int) int { return r*cols + c + 1 }
+	lat := func(r int) float64 { return 37.77 + float64(r)*0.001 }
+	lon := func(c int) float64 { return -122.42 + float64(c)*0.00125 }
+	nodes := make(map[string]GraphNode, rows*cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			nodes[strconv.Itoa(id(r, c))] = GraphNode{
+				ID:           id(r, c),
+				Lat:          lat(r),
+				Lon:          lon(c),
+				Neighbors:    map[string]NeighborInfo{},
+				TrafficLight: (r+c)%3 == 0,
+			}
+		}
+	}
+	link := func(r1, c1, r2, c2 int, speed float64) {
+		a, b := strconv.Itoa(id(r1, c1)), strconv.Itoa(id(r2, c2))
+		d := haversine(lat(r1), lon(c1), lat(r2), lon(c2))
+		nodes[a].Neighbors[b] = NeighborInfo{Distance: d, Speed: speed}
+		nodes[b].Neighbors[a] = NeighborInfo{Distance: d, Speed: speed}
+	}
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			if c+1 < cols {
+				speed := 50.0
+				if r%2 == 1 {
+					speed = 0
+				}
+				link(r, c, r, c+1, speed)
+			}
+			if r+1 < rows {
+				link(r, c, r+1, c, 30)
+			}
+		}
+	}
+	return nodes
+}
+
+func testGrid(rows, cols int) *RoadGraph {
+	return buildRoadGraph(testGridNodes(rows, cols))
+}
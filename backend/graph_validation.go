@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"math"
+)
+
+// GraphReport is what loading found wrong with a graph and what was done
+// about it.
+type GraphReport struct {
+	DanglingEdges int // to neighbours missing from the file; dropped
+	BadDistances  int // zero or negative; replaced by the straight-line distance
+	MissingSpeeds int // driven at defaultSpeed
+	DroppedTurns  int // turn rules naming roads the graph doesn't have
+	Components    int // strongly connected components
+	MainNodes     int // in the largest one
+	CutOffNodes   int // outside it: never spawned on or snapped to
+}
+
+// log prints the report, staying quiet about a graph with nothing wrong.
+func (r GraphReport) log() {
+	if r.DanglingEdges > 0 {
+		log.Printf("⚠️ Graph: edges to nodes missing from the file, dropped: %d\n", r.DanglingEdges)
+	}
+	if r.BadDistances > 0 {
+		log.Printf("⚠️ Graph: edges with a zero or negative distance, now the straight-line distance: %d\n", r.BadDistances)
+	}
+	if r.MissingSpeeds > 0 {
+		log.Printf("⚠️ Graph: edges with no speed, driven at %v km/h: %d\n", defaultSpeed, r.MissingSpeeds)
+	}
+	if r.DroppedTurns > 0 {
+		log.Printf("⚠️ Graph: turn rules for roads not in the graph, dropped: %d\n", r.DroppedTurns)
+	}
+	if r.CutOffNodes > 0 {
+		log.Printf("⚠️ Graph: nodes on islands that can't reach the rest of the map or be reached from it: %d (islands: %d); spawning and snapping use the largest connected part, %d nodes\n",
+			r.CutOffNodes, r.Components-1, r.MainNodes)
+	}
+}
+
+// repairEdges fixes distances routing can't use and counts edges without a
+// speed.
+func (g *RoadGraph) repairEdges() {
+	for i := int32(0); i < int32(g.NumNodes()); i++ {
+		lo, hi := g.Edges(i)
+		for e := lo; e < hi; e++ {
+			to := g.EdgeTo[e]
+			straight := haversine(g.Lat.At(i), g.Lon.At(i), g.Lat.At(to), g.Lon.At(to))
+			if d := g.EdgeDist.At(e); d < 0 || math.IsNaN(d) || (d == 0 && straight > 0) {
+				g.EdgeDist.Set(e, straight)
+				g.Report.BadDistances++
+			}
+			if !(g.EdgeSpeed.At(e) > 0) {
+				g.Report.MissingSpeeds++
+			}
+		}
+	}
+}
+
+// findMainComponent marks the nodes of the largest strongly connected
+// component: the part of the map where every node can reach every other.
+// Drivers and customers outside it could get stuck or never be reached.
+func (g *RoadGraph) findMainComponent() {
+	n := g.NumNodes()
+	comp, count := g.stronglyConnected()
+	sizes := make([]int, count)
+	for _, c := range comp {
+		sizes[c]++
+	}
+	main := int32(0)
+	for c := range sizes {
+		if sizes[c] > sizes[main] {
+			main = int32(c)
+		}
+	}
+
+	g.Main = make([]bool, n)
+	g.Report.Components = count
+	for i, c := range comp {
+		if c == main {
+			g.Main[i] = true
+			g.Report.MainNodes++
+		}
+	}
+	g.Report.CutOffNodes = n - g.Report.MainNodes
+}
+
+// stronglyConnected labels every node with its strongly connected component
+// and returns the labels and how many there are. It is Tarjan's algorithm
+// with an explicit stack, since a city's roads would overflow the call
+// stack.
+func (g *RoadGraph) stronglyConnected() ([]int32, int) {
+	n := g.NumNodes()
+	order := make([]int32, n) // visit order from 1; 0 is unvisited
+	low := make([]int32, n)
+	comp := make([]int32, n)
+	onStack := make([]bool, n)
+	var stack []int32
+	type frame struct {
+		node, edge int32 // the next edge of node to follow
+	}
+	var calls []frame
+	visited := int32(0)
+	count := 0
+
+	visit := func(v int32) {
+		visited++
+		order[v], low[v] = visited, visited
+		stack = append(stack, v)
+		onStack[v] = true
+		calls = append(calls, frame{v, g.EdgeStart[v]})
+	}
+	for root := int32(0); root < int32(n); root++ {
+		if order[root] != 0 {
+			continue
+		}
+		visit(root)
+		for len(calls) > 0 {
+			f := &calls[len(calls)-1]
+			v := f.node
+			if f.edge < g.EdgeStart[v+1] {
+				w := g.EdgeTo[f.edge]
+				f.edge++
+				if order[w] == 0 {
+					visit(w)
+				} else if onStack[w] && order[w] < low[v] {
+					low[v] = order[w]
+				}
+				continue
+			}
+
+			// Every edge of v is done: v either roots a component or hands
+			// its low link back to its caller
+			calls = calls[:len(calls)-1]
+			if low[v] == order[v] {
+				for {
+					w := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					onStack[w] = false
+					comp[w] = int32(count)
+					if w == v {
+						break
+					}
+				}
+				count++
+			}
+			if len(calls) > 0 {
+				if u := calls[len(calls)-1].node; low[v] < low[u] {
+					low[u] = low[v]
+				}
+			}
+		}
+	}
+	return comp, count
+}
@@ -7,10 +7,12 @@ import (
 	"strings"
 )
 
-func handleHeatmapData(w http.ResponseWriter, r *http.Request) {
+func handleHeatmapData(sim *Simulation, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var heatPoints [][]interface{}
-	for key, count := range heatmapCounts {
+	sim.driverMu.Lock()
+	defer sim.driverMu.Unlock()
+	for key, count := range sim.Heatmap {
 		parts := strings.Split(key, ",")
 		lat, _ := strconv.ParseFloat(parts[0], 64)
 		lon, _ := strconv.ParseFloat(parts[1], 64)
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runImportOSMCommand implements `ridesync import-osm`: it turns an
+// OpenStreetMap extract into the graph.json loadGraph reads. It replaces the
+// Python script that converted OSMnx exports, and prices roads the same way.
+func runImportOSMCommand(args []string) error {
+	fs := flag.NewFlagSet("import-osm", flag.ExitOnError)
+	in := fs.String("in", "", "OSM extract to read: .osm.pbf, or OSM XML (.osm)")
+	out := fs.String("out", "graph/graph.json", "graph file to write; a .bin extension writes the binary format")
+	bboxFlag := fs.String("bbox", "", "south,west,north,east to keep, e.g. 37.70,-122.52,37.83,-122.35; empty keeps everything")
+	fs.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	box, err := parseBBox(*bboxFlag)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	osm := newOSMCollector(box)
+	if strings.HasSuffix(strings.ToLower(*in), ".pbf") {
+		err = readOSMPBF(file, osm)
+	} else {
+		err = readOSMXML(file, osm)
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *in, err)
+	}
+
+	nodes := osm.graph()
+	if len(nodes) == 0 {
+		return fmt.Errorf("%s has no drivable roads inside the bounding box", *in)
+	}
+	if strings.HasSuffix(*out, ".bin") {
+		err = writeBinaryGraph(*out, buildRoadGraph(nodes))
+	} else {
+		err = writeGraph(*out, nodes)
+	}
+	if err != nil {
+		return err
+	}
+	if osm.skipped > 0 {
+		fmt.Printf("⚠️ Turn restrictions through a way, or with roads outside the bounding box, skipped: %d\n", osm.skipped)
+	}
+	fmt.Printf("✅ Wrote %d nodes from %d roads and %d turn restrictions to %s\n", len(nodes), len(osm.ways), osm.placed, *out)
+	return nil
+}
+
+// BBox is a latitude/longitude rectangle.
+type BBox struct {
+	South, West, North, East float64
+}
+
+// parseBBox reads "south,west,north,east"; empty is the whole world.
+func parseBBox(s string) (BBox, error) {
+	if s == "" {
+		return BBox{-90, -180, 90, 180}, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, fmt.Errorf("-bbox wants south,west,north,east, got %q", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return BBox{}, fmt.Errorf("-bbox: %q is not a number", p)
+		}
+		v[i] = f
+	}
+	box := BBox{v[0], v[1], v[2], v[3]}
+	if box.South >= box.North || box.West >= box.East {
+		return BBox{}, fmt.Errorf("-bbox: south must be below north and west left of east, got %q", s)
+	}
+	return box, nil
+}
+
+func (b BBox) contains(lat, lon float64) bool {
+	return lat >= b.South && lat <= b.North && lon >= b.West && lon <= b.East
+}
+
+// osmNode is the part of an OSM node the graph needs.
+type osmNode struct {
+	Lat, Lon float64
+	Highway  string // traffic_signals and stop matter
+}
+
+// osmWay is a drivable road.
+type osmWay struct {
+	ID   int64
+	Refs []int64
+	Tags map[string]string
+}
+
+// osmMember is a relation member: a node, way or relation in some role.
+type osmMember struct {
+	Type string // "node", "way" or "relation"
+	Ref  int64
+	Role string
+}
+
+// osmRestriction is a turn restriction relation: from one of the From ways
+// through the Via node onto one of the To ways, a turn of kind Turn is
+// banned ("no") or the only one allowed ("only").
+type osmRestriction struct {
+	Kind     string
+	Turn     string // left_turn, right_turn, straight_on, u_turn, or entry/exit
+	From, To []int64
+	Via      int64
+}
+
+// osmCollector keeps the nodes inside a bounding box, the drivable ways and
+// the turn restrictions, as the readers hand them over.
+type osmCollector struct {
+	box          BBox
+	nodes        map[int64]osmNode
+	ways         []osmWay
+	restrictions []osmRestriction
+	placed       int // restrictions graph() turned into turn rules
+	skipped      int // ones it couldn't place, or through a way
+}
+
+func newOSMCollector(box BBox) *osmCollector {
+	return &osmCollector{box: box, nodes: map[int64]osmNode{}}
+}
+
+func (c *osmCollector) addNode(id int64, lat, lon float64, tags map[string]string) {
+	if c.box.contains(lat, lon) {
+		c.nodes[id] = osmNode{Lat: lat, Lon: lon, Highway: tags["highway"]}
+	}
+}
+
+func (c *osmCollector) addWay(id int64, refs []int64, tags map[string]string) {
+	if drivable(tags) && len(refs) > 1 {
+		c.ways = append(c.ways, osmWay{ID: id, Refs: refs, Tags: tags})
+	}
+}
+
+// addRelation keeps the turn restrictions that apply to cars. Restrictions
+// through a way rather than a node are rare and counted as skipped.
+func (c *osmCollector) addRelation(members []osmMember, tags map[string]string) {
+	if tags["type"] != "restriction" {
+		return
+	}
+	value := tags["restriction:motorcar"]
+	if value == "" {
+		value = tags["restriction"]
+	}
+	kind, turn, ok := strings.Cut(value, "_")
+	if !ok || (kind != "no" && kind != "only") {
+		return
+	}
+	for _, v := range strings.Split(tags["except"], ";") {
+		if strings.TrimSpace(v) == "motorcar" {
+			return
+		}
+	}
+
+	r := osmRestriction{Kind: kind, Turn: turn, Via: -1}
+	for _, m := range members {
+		switch {
+		case m.Role == "from" && m.Type == "way":
+			r.From = append(r.From, m.Ref)
+		case m.Role == "to" && m.Type == "way":
+			r.To = append(r.To, m.Ref)
+		case m.Role == "via" && m.Type == "node" && r.Via < 0:
+			r.Via = m.Ref
+		case m.Role == "via":
+			c.skipped++
+			return
+		}
+	}
+	if len(r.From) > 0 && len(r.To) > 0 && r.Via >= 0 {
+		c.restrictions = append(c.restrictions, r)
+	}
+}
+
+// notDrivable are highway values cars can't use, after OSMnx's "drive"
+// network, which the old graph was exported with.
+var notDrivable = map[string]bool{
+	"abandoned": true, "bridleway": true, "bus_guideway": true, "construction": true, "corridor": true,
+	"cycleway": true, "elevator": true, "escalator": true, "footway": true, "no": true, "path": true,
+	"pedestrian": true, "planned": true, "platform": true, "proposed": true, "raceway": true,
+	"razed": true, "steps": true, "track": true, "bus_stop": true, "busway": true,
+}
+
+// notDrivableService are service road kinds left out of the graph.
+var notDrivableService = map[string]bool{
+	"alley": true, "driveway": true, "emergency_access": true, "parking": true, "parking_aisle": true, "private": true,
+}
+
+func drivable(tags map[string]string) bool {
+	highway := tags["highway"]
+	switch {
+	case highway == "" || notDrivable[highway] || tags["area"] == "yes":
+		return false
+	case tags["motor_vehicle"] == "no" || tags["motorcar"] == "no" || tags["access"] == "private":
+		return false
+	case notDrivableService[tags["service"]]:
+		return false
+	}
+	return true
+}
+
+// oneway returns whether a way may only be driven one way, and whether that
+// way is against the order of its nodes.
+func oneway(tags map[string]string) (oneway, reverse bool) {
+	switch tags["oneway"] {
+	case "yes", "true", "1":
+		return true, false
+	case "-1", "reverse":
+		return true, true
+	case "no", "false", "0":
+		return false, false
+	}
+	return tags["highway"] == "motorway" || tags["junction"] == "roundabout", false
+}
+
+// defaultSpeedByHighway is the speed in km/h of a road with no maxspeed,
+// the same table the Python extractor used.
+var defaultSpeedByHighway = map[string]float64{
+	"motorway":       110,
+	"motorway_link":  80,
+	"trunk":          100,
+	"trunk_link":     70,
+	"primary":        90,
+	"primary_link":   70,
+	"secondary":      70,
+	"secondary_link": 60,
+	"tertiary":       60,
+	"residential":    40,
+	"living_street":  20,
+	"service":        30,
+	"unclassified":   40,
+	"road":           40,
+}
+
+// parseMaxspeed works out a road's speed in km/h the way the Python
+// extractor's parse_maxspeed did: "25 mph" is converted, a bare number is
+// already km/h, and a road without a maxspeed gets its highway type's
+// default. Of several values, like "25 mph;35 mph", the first that parses is
+// used. A maxspeed where none does, like "signals", gives 0, which loadGraph
+// treats as no speed just as it did the extractor's null.
+func parseMaxspeed(maxspeed, highway string) float64 {
+	if maxspeed == "" {
+		if speed, ok := defaultSpeedByHighway[highway]; ok {
+			return speed
+		}
+		return 40
+	}
+	for _, value := range strings.Split(maxspeed, ";") {
+		if kmh := parseOneMaxspeed(value); kmh > 0 {
+			return kmh
+		}
+	}
+	return 0
+}
+
+// parseOneMaxspeed reads a single maxspeed value, or returns 0.
+func parseOneMaxspeed(value string) float64 {
+	lower := strings.ToLower(value)
+	if strings.Contains(lower, "mph") {
+		mph, err := strconv.ParseFloat(strings.TrimSpace(strings.ReplaceAll(lower, "mph", "")), 64)
+		if err != nil {
+			return 0
+		}
+		return mph * 1.60934
+	}
+	kmh, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+	return kmh
+}
+
+// graph links consecutive nodes of every way that are both inside the
+// bounding box. Nodes no kept road touches are left out.
+func (c *osmCollector) graph() map[string]GraphNode {
+	nodes := map[string]GraphNode{}
+	add := func(id int64) string {
+		key := strconv.FormatInt(id, 10)
+		if _, ok := nodes[key]; !ok {
+			n := c.nodes[id]
+			nodes[key] = GraphNode{
+				ID:           int(id),
+				Lat:          n.Lat,
+				Lon:          n.Lon,
+				Neighbors:    map[string]NeighborInfo{},
+				TrafficLight: n.Highway == "traffic_signals",
+				StopSign:     n.Highway == "stop",
+			}
+		}
+		return key
+	}
+
+	for _, way := range c.ways {
+		speed := parseMaxspeed(way.Tags["maxspeed"], way.Tags["highway"])
+		oneWay, reverse := oneway(way.Tags)
+		for i := 1; i < len(way.Refs); i++ {
+			from, to := way.Refs[i-1], way.Refs[i]
+			a, okA := c.nodes[from]
+			b, okB := c.nodes[to]
+			if !okA || !okB || from == to {
+				continue
+			}
+			info := NeighborInfo{Distance: haversine(a.Lat, a.Lon, b.Lat, b.Lon), Speed: speed}
+			fromKey, toKey := add(from), add(to)
+			if !oneWay || !reverse {
+				nodes[fromKey].Neighbors[toKey] = info
+			}
+			if !oneWay || reverse {
+				nodes[toKey].Neighbors[fromKey] = info
+			}
+		}
+	}
+
+	wayIndex := make(map[int64]int, len(c.ways))
+	for i, way := range c.ways {
+		wayIndex[way.ID] = i
+	}
+	c.placed = 0
+	for _, r := range c.restrictions {
+		if c.addRestriction(nodes, wayIndex, r) {
+			c.placed++
+		} else {
+			c.skipped++
+		}
+	}
+	return nodes
+}
+
+// restrictedTurn is the turn each restriction names; entry and exit
+// restrictions cover every turn between their roads.
+var restrictedTurn = map[string]turnClass{
+	"left_turn":   turnLeft,
+	"right_turn":  turnRight,
+	"straight_on": turnStraight,
+	"u_turn":      turnU,
+}
+
+// addRestriction adds r to its via node as turn rules between neighbors,
+// reporting whether any applied. A via node in the middle of a way has a
+// neighbor on each side, so where that leaves a choice the direction of the
+// restricted turn decides which pairs are meant.
+func (c *osmCollector) addRestriction(nodes map[string]GraphNode, wayIndex map[int64]int, r osmRestriction) bool {
+	viaKey := strconv.FormatInt(r.Via, 10)
+	via, ok := nodes[viaKey]
+	if !ok {
+		return false
+	}
+	neighbors := func(ways []int64) []int64 {
+		var out []int64
+		for _, id := range ways {
+			i, ok := wayIndex[id]
+			if !ok {
+				continue
+			}
+			refs := c.ways[i].Refs
+			for j, ref := range refs {
+				if ref != r.Via {
+					continue
+				}
+				if j > 0 {
+					out = append(out, refs[j-1])
+				}
+				if j < len(refs)-1 {
+					out = append(out, refs[j+1])
+				}
+			}
+		}
+		return out
+	}
+
+	var all, matching []TurnRule
+	want, classed := restrictedTurn[r.Turn]
+	for _, from := range neighbors(r.From) {
+		fromKey := strconv.FormatInt(from, 10)
+		if _, ok := nodes[fromKey].Neighbors[viaKey]; !ok {
+			continue // can't be driven towards via
+		}
+		for _, to := range neighbors(r.To) {
+			toKey := strconv.FormatInt(to, 10)
+			if _, ok := via.Neighbors[toKey]; !ok {
+				continue
+			}
+			rule := TurnRule{From: fromKey, To: toKey, Kind: r.Kind}
+			all = append(all, rule)
+			a, b, d := c.nodes[from], c.nodes[r.Via], c.nodes[to]
+			in, out := bearing(a.Lat, a.Lon, b.Lat, b.Lon), bearing(b.Lat, b.Lon, d.Lat, d.Lon)
+			if !classed || classifyTurn(in, out, from == to) == want {
+				matching = append(matching, rule)
+			}
+		}
+	}
+	if len(all) == 1 {
+		matching = all
+	}
+	via.Turns = append(via.Turns, matching...)
+	nodes[viaKey] = via
+	return len(matching) > 0
+}
+
+// writeGraph saves nodes in graph.json's format.
+func writeGraph(path string, nodes map[string]GraphNode) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating graph: %w", err)
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(nodes); err != nil {
+		file.Close()
+		return fmt.Errorf("writing graph: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fixtureBBox is the bounding box the comment in graph/fixtures/grid.osm
+// gives.
+const fixtureBBox = "37.770,-122.420,37.780,-122.400"
+
+// importFixture reads one of the grid fixtures inside fixtureBBox.
+func importFixture(t *testing.T, path string, read func(io.Reader, *osmCollector) error) (*osmCollector, map[string]GraphNode) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	box, err := parseBBox(fixtureBBox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	osm := newOSMCollector(box)
+	if err := read(file, osm); err != nil {
+		t.Fatal(err)
+	}
+	return osm, osm.graph()
+}
+
+// TestImportOSMFixture reads grid.osm and grid.osm.pbf, which
+// graph/fixtures/make_grid_pbf.py writes from it, and expects the same graph
+// from both.
+func TestImportOSMFixture(t *testing.T) {
+	readers := []struct {
+		name, path string
+		read       func(io.Reader, *osmCollector) error
+	}{
+		{"xml", "../graph/fixtures/grid.osm", readOSMXML},
+		{"pbf", "../graph/fixtures/grid.osm.pbf", readOSMPBF},
+	}
+	for _, r := range readers {
+		t.Run(r.name, func(t *testing.T) {
+			osm, nodes := importFixture(t, r.path, r.read)
+			checkFixtureGraph(t, osm, nodes)
+		})
+	}
+}
+
+func checkFixtureGraph(t *testing.T, osm *osmCollector, nodes map[string]GraphNode) {
+	t.Helper()
+	if len(nodes) != 9 {
+		t.Fatalf("imported %d nodes, want 9", len(nodes))
+	}
+	if _, ok := nodes["10"]; ok {
+		t.Error("node 10 is outside the bounding box but was imported")
+	}
+	edges := 0
+	for key, n := range nodes {
+		edges += len(n.Neighbors)
+		if n.TrafficLight != (key == "5") {
+			t.Errorf("node %s: traffic light %v", key, n.TrafficLight)
+		}
+		if n.StopSign != (key == "7") {
+			t.Errorf("node %s: stop sign %v", key, n.StopSign)
+		}
+	}
+	// Two-way 100, 102 and 104 up to the box give 12, one-way 101 and 103 give 4
+	if edges != 16 {
+		t.Errorf("imported %d edges, want 16", edges)
+	}
+
+	// The footway and parking aisle from 2 to 5 are left out
+	if _, ok := nodes["2"].Neighbors["5"]; ok {
+		t.Error("a road that isn't drivable was imported")
+	}
+	speeds := []struct {
+		from, to string
+		want     float64 // km/h; -1 for no edge
+	}{
+		{"1", "2", 40},
+		{"2", "1", 40},
+		{"4", "5", 25 * 1.60934},
+		{"5", "4", -1},
+		{"7", "8", 50},
+		{"7", "4", 40},
+		{"4", "7", -1},
+		{"3", "6", 0},
+		{"6", "9", 0},
+	}
+	for _, tt := range speeds {
+		info, ok := nodes[tt.from].Neighbors[tt.to]
+		switch {
+		case tt.want < 0 && ok:
+			t.Errorf("%s -> %s: one-way road imported the wrong way", tt.from, tt.to)
+		case tt.want >= 0 && !ok:
+			t.Errorf("%s -> %s: no edge", tt.from, tt.to)
+		case ok && math.Abs(info.Speed-tt.want) > 1e-9:
+			t.Errorf("%s -> %s: speed %v, want %v", tt.from, tt.to, info.Speed, tt.want)
+		}
+	}
+
+	if osm.placed != 2 || osm.skipped != 1 {
+		t.Errorf("placed %d turn restrictions and skipped %d, want 2 and 1", osm.placed, osm.skipped)
+	}
+	turns := map[string][]TurnRule{
+		"6": {{From: "5", To: "9", Kind: "no"}},
+		"3": {{From: "2", To: "2", Kind: "no"}},
+	}
+	for key, n := range nodes {
+		want := turns[key]
+		if len(n.Turns) != len(want) {
+			t.Errorf("node %s: turn rules %+v, want %+v", key, n.Turns, want)
+			continue
+		}
+		for i := range want {
+			if n.Turns[i] != want[i] {
+				t.Errorf("node %s: turn rules %+v, want %+v", key, n.Turns, want)
+			}
+		}
+	}
+}
+
+func TestParseMaxspeed(t *testing.T) {
+	tests := []struct {
+		maxspeed, highway string
+		want              float64
+	}{
+		{"", "residential", 40},
+		{"", "motorway", 110},
+		{"", "bus_guideway", 40},
+		{"50", "tertiary", 50},
+		{"25 mph", "secondary", 25 * 1.60934},
+		{"25 MPH", "secondary", 25 * 1.60934},
+		{"25 mph;35 mph", "secondary", 25 * 1.60934},
+		{"signals;30", "primary", 30},
+		{"signals", "primary", 0},
+		{"none;walk", "primary", 0},
+	}
+	for _, tt := range tests {
+		if got := parseMaxspeed(tt.maxspeed, tt.highway); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("parseMaxspeed(%q, %q) = %v, want %v", tt.maxspeed, tt.highway, got, tt.want)
+		}
+	}
+}
+
+// pbfBlob frames data as a file block of the given kind, with the blob
+// fields already encoded.
+func pbfBlob(kind string, blob []byte) []byte {
+	header := pbfBytes(1, []byte(kind))
+	header = append(header, pbfVarint(3, uint64(len(blob)))...)
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(header)))
+	out = append(out, header...)
+	return append(out, blob...)
+}
+
+func pbfVarint(field int, v uint64) []byte {
+	return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(field<<3)), v)
+}
+
+func pbfBytes(field int, data []byte) []byte {
+	out := binary.AppendUvarint(binary.AppendUvarint(nil, uint64(field<<3|2)), uint64(len(data)))
+	return append(out, data...)
+}
+
+func TestReadOSMPBFRejectsDamage(t *testing.T) {
+	fixture, err := os.ReadFile("../graph/fixtures/grid.osm.pbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readPBF := func(data []byte) error {
+		box, err := parseBBox(fixtureBBox)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readOSMPBF(bytes.NewReader(data), newOSMCollector(box))
+	}
+
+	// Cut inside the first size prefix, the first header and the last blob
+	for _, n := range []int{2, 10, len(fixture) - 1} {
+		if err := readPBF(fixture[:n]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("cut to %d bytes: got %v, want unexpected EOF", n, err)
+		}
+	}
+
+	// A blob that holds a cut zlib stream frames correctly but can't be read
+	data := []byte("not a primitive block")
+	var zipped bytes.Buffer
+	zw := zlib.NewWriter(&zipped)
+	zw.Write(data)
+	zw.Close()
+	cut := append(pbfVarint(2, uint64(len(data))), pbfBytes(3, zipped.Bytes()[:zipped.Len()/2])...)
+	if err := readPBF(pbfBlob("OSMData", cut)); err == nil {
+		t.Error("read a blob with a cut zlib stream")
+	}
+
+	// lz4_data is field 6 of Blob and zstd_data field 7
+	for _, field := range []int{6, 7} {
+		blob := append(pbfVarint(2, 4), pbfBytes(field, []byte{1, 2, 3, 4})...)
+		err := readPBF(pbfBlob("OSMData", blob))
+		if err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("blob field %d: got %v, want unsupported compression", field, err)
+		}
+	}
+}
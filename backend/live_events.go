@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types pushed to /events subscribers.
+const (
+	EventSnapshot          = "snapshot"          // Data is a LiveSnapshot, sent once per connection
+	EventDriverMoved       = "driverMoved"       // Data is a DriverMove
+	EventRouteChanged      = "routeChanged"      // Data is the whole Driver, new GraphPath included
+	EventETAChanged        = "etaChanged"        // Data is the new ETA in minutes
+	EventCustomerEnqueued  = "customerEnqueued"  // Data is the Customer
+	EventCustomerAssigned  = "customerAssigned"  // Data is the Customer, now off the queue
+	EventPickup            = "pickup"            // Data is the Customer
+	EventDropoff           = "dropoff"           // Data is the Customer
+	EventCustomerCancelled = "customerCancelled" // Data is the Customer, off the queue or their driver's route
+	EventCustomerExpired   = "customerExpired"   // Data is the Customer, off the queue
+)
+
+// LiveEvent is one incremental change to the simulation state.
+type LiveEvent struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Driver string    `json:"driver,omitempty"`
+	Data   any       `json:"data"`
+}
+
+// LiveSnapshot is the full state a new subscriber starts from.
+type LiveSnapshot struct {
+	Drivers []Driver   `json:"drivers"`
+	CustQue []Customer `json:"custque"`
+}
+
+// DriverMove is the part of a driver that changes on every step.
+type DriverMove struct {
+	Lat           float64   `json:"lat"`
+	Lon           float64   `json:"lon"`
+	PathIndex     int       `json:"pathIndex"`
+	CurrentSpeed  float64   `json:"currentSpeed"`
+	AnimationTime time.Time `json:"animationTime"`
+}
+
+// eventBuffer is how many events a subscriber may fall behind before it is
+// cut off.
+const eventBuffer = 512
+
+// EventRecorder receives every event a hub publishes, in order, before any
+// subscriber does.
+type EventRecorder interface {
+	Record(ev LiveEvent)
+}
+
+// EventHub fans events out to subscribers without ever blocking the
+// publisher. A subscriber whose buffer fills is dropped; browsers'
+// EventSource reconnects on its own and resyncs from a fresh snapshot.
+type EventHub struct {
+	mu       sync.Mutex
+	clients  map[chan LiveEvent]struct{}
+	closed   bool
+	recorder EventRecorder
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{clients: map[chan LiveEvent]struct{}{}}
+}
+
+// Subscribe returns a channel of events that is closed when the subscriber
+// falls behind or the hub is closed.
+func (h *EventHub) Subscribe() chan LiveEvent {
+	ch := make(chan LiveEvent, eventBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.clients[ch] = struct{}{}
+	return ch
+}
+
+func (h *EventHub) Unsubscribe(ch chan LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// Close disconnects every subscriber and turns away new ones.
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.clients {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// SetRecorder makes r see every event published from now on; nil stops
+// recording.
+func (h *EventHub) SetRecorder(r EventRecorder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.recorder = r
+}
+
+// Record hands ev to the recorder only, for state subscribers already have.
+func (h *EventHub) Record(ev LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.recorder != nil {
+		h.recorder.Record(ev)
+	}
+}
+
+func (h *EventHub) Publish(ev LiveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.recorder != nil {
+		h.recorder.Record(ev)
+	}
+	for ch := range h.clients {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// publishRoute announces a driver's new route, plus its ETA if that moved.
+func (s *Simulation) publishRoute(driver *Driver, oldETA float64, now time.Time) {
+	s.Events.Publish(LiveEvent{Type: EventRouteChanged, Time: now, Driver: driver.Name, Data: *driver})
+	if driver.ETA != oldETA {
+		s.Events.Publish(LiveEvent{Type: EventETAChanged, Time: now, Driver: driver.Name, Data: driver.ETA})
+	}
+}
+
+// streamEvents serves /events as Server-Sent Events: a snapshot first, then
+// every change as it happens.
+func streamEvents(sim *Simulation, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Subscribe and snapshot under both locks so no event is missed or
+	// already reflected in the snapshot
+	sim.driverMu.Lock()
+	sim.queueMu.Lock()
+	events := sim.Events.Subscribe()
+	snapshot := LiveSnapshot{
+		Drivers: append([]Driver(nil), sim.Drivers...),
+		CustQue: append([]Customer(nil), sim.Queue...),
+	}
+	sim.queueMu.Unlock()
+	sim.driverMu.Unlock()
+	defer sim.Events.Unsubscribe(events)
+
+	if err := writeEvent(w, LiveEvent{Type: EventSnapshot, Time: sim.Clock.Now(), Data: snapshot}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				if !sim.stopped() {
+					log.Println("⚠️ Dropped a slow /events client")
+				}
+				return
+			}
+			if err := writeEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, ev LiveEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// matchCandidates is how many of the closest available drivers (by straight-line
+// distance) get a full road-network ETA. Routing every driver for every
+// customer would be wasteful; the straight-line order is a good pre-filter.
+const matchCandidates = 5
+
+// MatchEnv is what ranking drivers needs from a simulation.
+type MatchEnv struct {
+	Graph     *RoadGraph
+	MaxDetour time.Duration // how much pooling may delay riders already on a route
+}
+
+func (s *Simulation) matchEnv() MatchEnv {
+	return MatchEnv{Graph: s.Graph, MaxDetour: s.Config.MaxDetour}
+}
+
+// DriverCandidate is one driver that could take a customer, either idle or
+// pooling with a seat to spare.
+type DriverCandidate struct {
+	Index    int         `json:"index"` // position in the driver list that was ranked
+	Name     string      `json:"name"`
+	ETA      float64     `json:"eta"`      // minutes to the pickup by road
+	Distance float64     `json:"distance"` // straight-line meters to the pickup
+	Path     []GraphNode `json:"-"`        // to the first stop of Stops
+	Stops    []Stop      `json:"-"`        // the driver's stops with the customer added
+}
+
+// rankDrivers routes the k available drivers nearest the customer and returns
+// the ones that can fit the pickup into their route, fastest first.
+func rankDrivers(env MatchEnv, drivers []Driver, customer Customer, k int) []DriverCandidate {
+	var nearby []DriverCandidate
+	for i := range drivers {
+		if !drivers[i].canTakeCustomer() {
+			continue
+		}
+		nearby = append(nearby, DriverCandidate{
+			Index:    i,
+			Name:     drivers[i].Name,
+			Distance: haversine(drivers[i].Lat, drivers[i].Lon, customer.Lat, customer.Lon),
+		})
+	}
+	sort.SliceStable(nearby, func(a, b int) bool { return nearby[a].Distance < nearby[b].Distance })
+	if len(nearby) > k {
+		nearby = nearby[:k]
+	}
+
+	ranked := nearby[:0]
+	for _, c := range nearby {
+		driver := &drivers[c.Index]
+		timer := newLegTimer(env.Graph)
+		stops, eta, ok := bestInsertion(driver, customer, timer, env.MaxDetour)
+		if !ok {
+			continue
+		}
+		c.ETA = eta
+		c.Stops = stops
+		c.Path = timer.route(driver.Lat, driver.Lon, stops[0].Lat, stops[0].Lon).path
+		ranked = append(ranked, c)
+	}
+	sort.SliceStable(ranked, func(a, b int) bool { return ranked[a].ETA < ranked[b].ETA })
+	return ranked
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// TravelMatrix is the travel time and length of the fastest route under
+// routeCost from each source node to each target node, indexed
+// [source][target]. Pairs with no route between them are +Inf in both.
+type TravelMatrix struct {
+	Seconds [][]float64
+	Meters  [][]float64
+}
+
+type matrixCell struct {
+	seconds, meters float64
+}
+
+var noRoute = matrixCell{math.Inf(1), math.Inf(1)}
+
+// matrixCache remembers cells already worked out for one graph. It is
+// emptied when it fills up rather than evicting cell by cell.
+type matrixCache struct {
+	mu    sync.Mutex
+	g     *RoadGraph
+	cells map[[2]int32]matrixCell
+}
+
+const matrixCacheLimit = 1 << 20
+
+var travelCache matrixCache
+
+// TravelTimes fills in a TravelMatrix from sources to targets, taking what
+// it can from the cache and routing the rest in one batch. With a
+// contraction hierarchy the batch is answered from buckets; without one,
+// by a Dijkstra search out of each source.
+func (g *RoadGraph) TravelTimes(sources, targets []int32) TravelMatrix {
+	m := TravelMatrix{Seconds: make([][]float64, len(sources)), Meters: make([][]float64, len(sources))}
+	for i := range sources {
+		m.Seconds[i] = make([]float64, len(targets))
+		m.Meters[i] = make([]float64, len(targets))
+	}
+
+	// Take what the cache has, and route every source missing a cell to
+	// every target missing one
+	cells := make([][]matrixCell, len(sources))
+	hit := make([][]bool, len(sources))
+	var rows, cols []int32
+	rowOf, colOf := map[int32]int{}, map[int32]int{}
+	travelCache.mu.Lock()
+	if travelCache.g != g {
+		travelCache.g, travelCache.cells = g, map[[2]int32]matrixCell{}
+	}
+	for i, s := range sources {
+		cells[i] = make([]matrixCell, len(targets))
+		hit[i] = make([]bool, len(targets))
+		for j, t := range targets {
+			if cells[i][j], hit[i][j] = travelCache.cells[[2]int32{s, t}]; hit[i][j] {
+				continue
+			}
+			if _, ok := rowOf[s]; !ok {
+				rowOf[s] = len(rows)
+				rows = append(rows, s)
+			}
+			if _, ok := colOf[t]; !ok {
+				colOf[t] = len(cols)
+				cols = append(cols, t)
+			}
+		}
+	}
+	travelCache.mu.Unlock()
+
+	found := make([][]matrixCell, len(rows))
+	for i := range found {
+		found[i] = make([]matrixCell, len(cols))
+		for j := range found[i] {
+			found[i][j] = noRoute
+		}
+	}
+	if len(rows) > 0 {
+		if ch := g.CH; ch != nil && ch.Model == routeCost {
+			ch.matrix(g, rows, cols, found)
+		} else {
+			dijkstraMatrix(g, rows, cols, found)
+		}
+
+		travelCache.mu.Lock()
+		if travelCache.g != g || len(travelCache.cells)+len(rows)*len(cols) > matrixCacheLimit {
+			travelCache.g, travelCache.cells = g, map[[2]int32]matrixCell{}
+		}
+		for i, s := range rows {
+			for j, t := range cols {
+				travelCache.cells[[2]int32{s, t}] = found[i][j]
+			}
+		}
+		travelCache.mu.Unlock()
+	}
+
+	for i, s := range sources {
+		for j, t := range targets {
+			cell := cells[i][j]
+			if !hit[i][j] {
+				cell = found[rowOf[s]][colOf[t]]
+			}
+			m.Seconds[i][j], m.Meters[i][j] = cell.seconds, cell.meters
+		}
+	}
+	return m
+}
+
+// dijkstraMatrix searches out of each source until it has reached every
+// target. sources and targets must not repeat.
+func dijkstraMatrix(g *RoadGraph, sources, targets []int32, cells [][]matrixCell) {
+	column := make(map[int32]int, len(targets))
+	for j, t := range targets {
+		column[t] = j
+	}
+	zero := func(int32) float64 { return 0 }
+	for i, src := range sources {
+		row := cells[i]
+		left := len(targets)
+		if j, ok := column[src]; ok {
+			row[j] = matrixCell{}
+			left--
+		}
+		s := acquireSearch(g)
+		lo, hi := g.Edges(src)
+		for e := lo; e < hi; e++ {
+			s.visit(e, -1, routeCost.EdgeCost(g, e))
+			heap.Push(&s.open, openItem{edge: e, f: s.g[e]})
+		}
+		for left > 0 && s.open.Len() > 0 {
+			item := heap.Pop(&s.open).(openItem)
+			if s.closed[item.edge] == s.stamp {
+				continue
+			}
+			s.closed[item.edge] = s.stamp
+			// The first edge settled into a node is the cheapest way there
+			if j, ok := column[g.EdgeTo[item.edge]]; ok && math.IsInf(row[j].seconds, 1) {
+				meters := 0.0
+				for e := item.edge; e != -1; e = s.parent[e] {
+					meters += g.EdgeDist.At(e)
+				}
+				row[j] = matrixCell{item.f, meters}
+				left--
+			}
+			s.relax(g, routeCost, item.edge, zero)
+		}
+		searchPool.Put(s)
+	}
+}
+
+// chBucket records that a backward search from target column reached a
+// state, and at what cost.
+type chBucket struct {
+	column          int
+	seconds, meters float64
+}
+
+// chClimb is a one-sided search up the hierarchy that runs until it runs
+// out of states.
+type chClimb struct {
+	stamp  uint32
+	seen   []uint32
+	dist   []float64
+	meters []float64
+	open   openList
+}
+
+func (c *chClimb) reset() {
+	c.stamp++
+	c.open = c.open[:0]
+}
+
+func (c *chClimb) reach(v int32, d, meters float64) {
+	if c.seen[v] == c.stamp && d >= c.dist[v] {
+		return
+	}
+	c.seen[v], c.dist[v], c.meters[v] = c.stamp, d, meters
+	heap.Push(&c.open, openItem{edge: v, f: d})
+}
+
+// run climbs over start/to/cost/meters arcs, stalling as route does on the
+// stall arcs, and calls settled with each state it settles.
+func (c *chClimb) run(start, to []int32, cost, meters []float64, stallStart, stallTo []int32, stallCost []float64, settled func(v int32, d, meters float64)) {
+	for c.open.Len() > 0 {
+		item := heap.Pop(&c.open).(openItem)
+		v := item.edge
+		if item.f > c.dist[v] {
+			continue
+		}
+		stalled := false
+		for a := stallStart[v]; a < stallStart[v+1] && !stalled; a++ {
+			w := stallTo[a]
+			stalled = c.seen[w] == c.stamp && c.dist[w]+stallCost[a] < item.f
+		}
+		if stalled {
+			continue
+		}
+		settled(v, item.f, c.meters[v])
+		for a := start[v]; a < start[v+1]; a++ {
+			c.reach(to[a], item.f+cost[a], c.meters[v]+meters[a])
+		}
+	}
+}
+
+// matrix fills cells the way many-to-many queries usually run on a
+// hierarchy: a backward search from each target leaves a bucket entry at
+// every state it settles, then a forward search from each source checks the
+// buckets of the states it settles. sources and targets must not repeat.
+func (ch *ContractionHierarchy) matrix(g *RoadGraph, sources, targets []int32, cells [][]matrixCell) {
+	n := len(ch.UpStart) - 1
+	c := &chClimb{seen: make([]uint32, n), dist: make([]float64, n), meters: make([]float64, n)}
+	buckets := map[int32][]chBucket{}
+	for j, t := range targets {
+		c.reset()
+		for k := ch.inStart[t]; k < ch.inStart[t+1]; k++ {
+			c.reach(ch.inEdge[k], 0, 0)
+		}
+		c.run(ch.DownStart, ch.DownFrom, ch.DownCost, ch.downMeters, ch.UpStart, ch.UpTo, ch.UpCost, func(v int32, d, meters float64) {
+			buckets[v] = append(buckets[v], chBucket{j, d, meters})
+		})
+	}
+	for i, src := range sources {
+		row := cells[i]
+		c.reset()
+		lo, hi := g.Edges(src)
+		for e := lo; e < hi; e++ {
+			c.reach(e, routeCost.EdgeCost(g, e), g.EdgeDist.At(e))
+		}
+		c.run(ch.UpStart, ch.UpTo, ch.UpCost, ch.upMeters, ch.DownStart, ch.DownFrom, ch.DownCost, func(v int32, d, meters float64) {
+			for _, b := range buckets[v] {
+				if d+b.seconds < row[b.column].seconds {
+					row[b.column] = matrixCell{d + b.seconds, meters + b.meters}
+				}
+			}
+		})
+		for j, t := range targets {
+			if t == src {
+				row[j] = matrixCell{}
+			}
+		}
+	}
+}
+
+// MatrixRequest asks for travel times from each source point to each target
+// point.
+type MatrixRequest struct {
+	Sources []LatLon `json:"sources"`
+	Targets []LatLon `json:"targets"`
+}
+
+// MatrixResponse gives the node each point snapped to, and the matrix
+// between them. Pairs with no route are null.
+type MatrixResponse struct {
+	Sources []int           `json:"sources"`
+	Targets []int           `json:"targets"`
+	Seconds [][]matrixValue `json:"seconds"`
+	Meters  [][]matrixValue `json:"meters"`
+}
+
+// matrixValue is a matrix entry that encodes +Inf as null.
+type matrixValue float64
+
+func (v matrixValue) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(v), 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, float64(v), 'f', -1, 64), nil
+}
+
+const maxMatrixCells = 10000
+
+func handleMatrix(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req MatrixRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Sources) == 0 || len(req.Targets) == 0 {
+		http.Error(w, "sources and targets must not be empty", http.StatusBadRequest)
+		return
+	}
+	if len(req.Sources)*len(req.Targets) > maxMatrixCells {
+		http.Error(w, fmt.Sprintf("at most %d sources × targets per request", maxMatrixCells), http.StatusBadRequest)
+		return
+	}
+
+	g := roadGraph
+	snap := func(points []LatLon) ([]int32, []int, bool) {
+		nodes, ids := make([]int32, len(points)), make([]int, len(points))
+		for i, p := range points {
+			node, ok := g.Spatial.Nearest(p.Lat, p.Lon, snapOptions)
+			if !ok {
+				return nil, nil, false
+			}
+			nodes[i], ids[i] = node, g.NodeID[node]
+		}
+		return nodes, ids, true
+	}
+	sources, sourceIDs, okSources := snap(req.Sources)
+	targets, targetIDs, okTargets := snap(req.Targets)
+	if !okSources || !okTargets {
+		http.Error(w, "no road to snap to", http.StatusUnprocessableEntity)
+		return
+	}
+
+	m := g.TravelTimes(sources, targets)
+	resp := MatrixResponse{
+		Sources: sourceIDs,
+		Targets: targetIDs,
+		Seconds: make([][]matrixValue, len(sources)),
+		Meters:  make([][]matrixValue, len(sources)),
+	}
+	for i := range sources {
+		resp.Seconds[i] = make([]matrixValue, len(targets))
+		resp.Meters[i] = make([]matrixValue, len(targets))
+		for j := range targets {
+			resp.Seconds[i][j], resp.Meters[i][j] = matrixValue(m.Seconds[i][j]), matrixValue(m.Meters[i][j])
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
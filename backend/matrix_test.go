@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// wantMatrix routes sources to targets without the cache.
+func wantMatrix(g *RoadGraph, sources, targets []int32) [][]matrixCell {
+	cells := make([][]matrixCell, len(sources))
+	for i := range cells {
+		cells[i] = make([]matrixCell, len(targets))
+		for j := range cells[i] {
+			cells[i][j] = noRoute
+		}
+	}
+	dijkstraMatrix(g, sources, targets, cells)
+	return cells
+}
+
+func checkMatrix(t *testing.T, g *RoadGraph, sources, targets []int32, got TravelMatrix) {
+	t.Helper()
+	want := wantMatrix(g, sources, targets)
+	for i, s := range sources {
+		for j, d := range targets {
+			w := want[i][j]
+			if math.Abs(got.Seconds[i][j]-w.seconds) > 1e-9 || math.Abs(got.Meters[i][j]-w.meters) > 1e-9 {
+				t.Errorf("%d -> %d: got %.3f s %.3f m, want %.3f s %.3f m",
+					s, d, got.Seconds[i][j], got.Meters[i][j], w.seconds, w.meters)
+			}
+			if s != d && got.Seconds[i][j] == 0 {
+				t.Errorf("%d -> %d: zero travel time between different nodes", s, d)
+			}
+		}
+	}
+}
+
+func TestTravelTimesCacheNearLimit(t *testing.T) {
+	g := testGrid(8, 8)
+	defer func() { travelCache = matrixCache{} }()
+
+	cached := []int32{0, 9, 27}
+	checkMatrix(t, g, cached, cached, g.TravelTimes(cached, cached))
+
+	// Fill the cache with cells for nodes that don't exist until one more
+	// batch will overflow it, emptying it while the request still needs the
+	// cells it found there
+	travelCache.mu.Lock()
+	for k := int32(0); len(travelCache.cells) < matrixCacheLimit-2; k++ {
+		travelCache.cells[[2]int32{-1, k}] = matrixCell{1, 1}
+	}
+	travelCache.mu.Unlock()
+
+	// Only node 40's row is routed; the other rows come from the cache
+	sources := []int32{0, 9, 40, 27}
+	targets := cached
+	checkMatrix(t, g, sources, targets, g.TravelTimes(sources, targets))
+	if n := len(travelCache.cells); n >= matrixCacheLimit {
+		t.Errorf("cache holds %d cells after overflowing, want it emptied", n)
+	}
+	// What was just routed is cached, and reads back the same
+	checkMatrix(t, g, sources, targets, g.TravelTimes(sources, targets))
+}
+
+func TestTravelTimesHierarchy(t *testing.T) {
+	g := testGrid(6, 7)
+	defer func() { travelCache = matrixCache{} }()
+	g.CH = buildContraction(g, routeCost)
+
+	sources := []int32{0, 5, 20, 41}
+	targets := []int32{3, 5, 17, 30, 41}
+	checkMatrix(t, g, sources, targets, g.TravelTimes(sources, targets))
+}
@@ -2,130 +2,222 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
-	"strconv"
 	"time"
 )
 
-func moveDrivers() {
-	ticker := time.NewTicker(200 * time.Millisecond)
-	defer ticker.Stop()
+// moveTick is how often a polling clock wakes the driver loop.
+const moveTick = 200 * time.Millisecond
 
+// moveDrivers is the driver loop for real-time and scaled clocks. It polls
+// so newly assigned customers are picked up promptly; runDispatcher matches
+// customers next to it.
+func (s *Simulation) moveDrivers() {
+	wake := s.Clock.Now()
 	for {
-		<-ticker.C
-		driverMutex.Lock()
+		now := s.Clock.SleepUntil(wake)
+		if s.stopped() {
+			return
+		}
 
-		now := time.Now()
+		s.driverMu.Lock()
+		s.spawnArrivals(now)
+		s.stepDrivers(now)
+		s.driverMu.Unlock()
+		wake = now.Add(moveTick)
+	}
+}
 
-		for i := range driverList {
-			driver := &driverList[i]
+// runEvents replaces moveDrivers and runDispatcher on an event clock. Time
+// only moves when this loop sleeps, so it also dispatches, on the same
+// clock, rather than leaving that to a wall-clock ticker.
+func (s *Simulation) runEvents() {
+	wake := s.Clock.Now()
+	for {
+		now := s.Clock.SleepUntil(wake)
+		if s.stopped() {
+			return
+		}
+		_, wake = s.stepEvents(now)
+	}
+}
 
-			// Skip if not yet time to move
-			if now.Before(driver.MoveTime) {
-				continue
-			}
+// stepEvents is one step of an event-driven run at now: customers due by
+// now arrive, waiting ones are dispatched, and drivers due by now move. It
+// returns how many customers arrived and when the next step is due: the
+// next move or arrival, or a tick on if nothing is scheduled.
+func (s *Simulation) stepEvents(now time.Time) (int, time.Time) {
+	s.driverMu.Lock()
+	arrived, arrival := s.spawnArrivals(now)
+	s.driverMu.Unlock()
 
-			// 🚗 Has a path and more steps
-			if len(driver.GraphPath) > 0 && driver.PathIndex < len(driver.GraphPath) {
-				// Current and next step
-				var prev GraphNode
-				if driver.PathIndex > 0 {
-					prev = driver.GraphPath[driver.PathIndex-1]
-				} else {
-					prev = GraphNode{Lat: driver.Lat, Lon: driver.Lon}
-				}
-				next := driver.GraphPath[driver.PathIndex]
+	s.dispatchQueued(now)
 
-				// Move driver
-				driver.Lat = next.Lat
-				driver.Lon = next.Lon
-				driver.PathIndex++
+	s.driverMu.Lock()
+	due := s.stepDrivers(now)
+	s.driverMu.Unlock()
 
-				// Compute distance, speed, and delay
-				distance := haversine(prev.Lat, prev.Lon, next.Lat, next.Lon)
+	wake := now.Add(moveTick)
+	if !due.IsZero() {
+		wake = due
+	}
+	if !arrival.IsZero() && arrival.Before(wake) {
+		wake = arrival
+	}
+	return arrived, wake
+}
 
-				edgeInfo := graph[strconv.Itoa(prev.ID)].Neighbors[strconv.Itoa(next.ID)]
-				variation := 0.9 + rand.Float64()*0.2
-				driver.CurrentSpeed = edgeInfo.Speed * variation
+// stepDrivers advances every driver whose move is due at now and returns the
+// earliest time any driver is next due, or the zero time if none is waiting.
+// Callers must hold driverMu.
+func (s *Simulation) stepDrivers(now time.Time) time.Time {
+	g, rng := s.Graph, s.Rand
+	s.Stats.observe(now, s.Drivers)
 
-				if driver.CurrentSpeed <= 0 {
+	for i := range s.Drivers {
+		driver := &s.Drivers[i]
 
-					driver.CurrentSpeed = 40.0 // fallback default
-				}
-				driver.ResourceLeft -= distance * 0.001 // Fuel usage (0.001 L per meter)
-				if driver.ResourceLeft <= 0 {
-					driver.ResourceLeft = 40.0
-				}
+		// Skip if not yet time to move
+		if now.Before(driver.MoveTime) {
+			continue
+		}
 
-				seconds := (distance / (driver.CurrentSpeed * 1000)) * 3600
+		// 🚗 Has a path and more steps
+		if len(driver.GraphPath) > 0 && driver.PathIndex < len(driver.GraphPath) {
+			// Current and next step
+			var prev GraphNode
+			if driver.PathIndex > 0 {
+				prev = driver.GraphPath[driver.PathIndex-1]
+			} else {
+				prev = GraphNode{Lat: driver.Lat, Lon: driver.Lon}
+			}
+			next := driver.GraphPath[driver.PathIndex]
 
-				moveDelay := time.Duration(seconds * float64(time.Second))
-				driver.AnimationTime = now.Add(moveDelay)
-				// Apply pause AFTER animation at current node
-				if next.TrafficLight && rand.Float64() < 0.3 {
-					moveDelay += 25 * time.Second
-				} else if next.StopSign && rand.Float64() < 0.7 {
-					moveDelay += 5 * time.Second
-				}
+			// Move driver
+			driver.Lat = next.Lat
+			driver.Lon = next.Lon
+			driver.PathIndex++
 
-				driver.MoveTime = now.Add(moveDelay)
+			// Compute distance, speed, and delay
+			distance := haversine(prev.Lat, prev.Lon, next.Lat, next.Lon)
 
-				// fmt.Println(driver.MoveTime)
-				continue // Go to next driver
+			speed := 0.0
+			if edge, ok := g.segmentEdge(prev, next); ok {
+				speed = g.EdgeSpeed.At(edge)
 			}
+			variation := 0.9 + rng.Float64()*0.2
+			driver.CurrentSpeed = speed * variation
 
-			// ✅ Reached end of path — handle logic
-			if driver.PathIndex >= len(driver.GraphPath) {
-				if driver.HasCustomer && driver.OnPickupLeg {
-					// Begin drop-off
-					dest := driver.Customer
-					path := aStarGraphCoords(driver.Lat, driver.Lon, dest.DestinationLat, dest.DestinationLon)
-					driver.GraphPath = path
-					driver.PathIndex = 0
-					driver.OnPickupLeg = false
-					for _, node := range path {
-						key := fmt.Sprintf("%.5f,%.5f", node.Lat, node.Lon) // Round to reduce duplicates
-						heatmapCounts[key]++
-					}
-					fmt.Printf("%s picked up %s — heading to drop-off\n", driver.Name, dest.Name)
+			if driver.CurrentSpeed <= 0 {
 
-				} else if driver.HasCustomer && !driver.OnPickupLeg {
-					// Drop-off complete
-					fmt.Printf("%s dropped off %s\n", driver.Name, driver.Customer.Name)
-					driver.HasCustomer = false
-					driver.Customer = Customer{}
-					driver.OnPickupLeg = false
+				driver.CurrentSpeed = defaultSpeed
+			}
+			liters := distance / 1000 * driver.FuelPerKm
+			driver.ResourceLeft -= liters
+			s.Stats.FuelUsed += liters
+			s.openTrips.moved(driver.Name, next, distance, liters)
+			if driver.ResourceLeft <= 0 {
+				driver.ResourceLeft = driver.FuelCapacity
+			}
 
-					// Resume roaming
-					startID := findNearestNode(driver.Lat, driver.Lon)
-					destID := getRandomNodeID()
-					driver.GraphPath = aStarGraph(startID, destID)
-					driver.PathIndex = 0
+			seconds := (distance / (driver.CurrentSpeed * 1000)) * 3600
+
+			moveDelay := time.Duration(seconds * float64(time.Second))
+			driver.AnimationTime = now.Add(moveDelay)
+			// Apply pause AFTER animation at current node
+			if next.TrafficLight && rng.Float64() < 0.3 {
+				moveDelay += 25 * time.Second
+			} else if next.StopSign && rng.Float64() < 0.7 {
+				moveDelay += 5 * time.Second
+			}
 
+			driver.MoveTime = now.Add(moveDelay)
+			s.Events.Publish(LiveEvent{Type: EventDriverMoved, Time: now, Driver: driver.Name, Data: DriverMove{
+				Lat:           driver.Lat,
+				Lon:           driver.Lon,
+				PathIndex:     driver.PathIndex,
+				CurrentSpeed:  driver.CurrentSpeed,
+				AnimationTime: driver.AnimationTime,
+			}})
+
+			// fmt.Println(driver.MoveTime)
+			continue // Go to next driver
+		}
+
+		// ✅ Reached end of path — handle logic
+		if driver.PathIndex >= len(driver.GraphPath) {
+			if len(driver.Stops) > 0 {
+				stop := driver.Stops[0]
+				driver.Stops = driver.Stops[1:]
+				if stop.Kind == StopPickup {
+					stop.Customer.setStatus(CustomerOnTrip, now)
+					driver.setRiderStatus(stop.Customer.Id, CustomerOnTrip, now)
+					s.Stats.pickedUp(driver, stop.Customer, now)
+					s.openTrips.pickedUp(driver.Name, stop.Customer, now)
+					s.Events.Publish(LiveEvent{Type: EventPickup, Time: now, Driver: driver.Name, Data: stop.Customer})
+					fmt.Printf("%s picked up %s\n", driver.Name, stop.Customer.Name)
 				} else {
-					// Idle roaming
-					startID := findNearestNode(driver.Lat, driver.Lon)
-					destID := getRandomNodeID()
-					driver.GraphPath = aStarGraph(startID, destID)
-					for i := 0; i < 5 && len(driver.GraphPath) == 0; i++ {
-						destID = getRandomNodeID()
-						driver.GraphPath = aStarGraph(startID, destID)
+					stop.Customer.setStatus(CustomerCompleted, now)
+					fmt.Printf("%s dropped off %s\n", driver.Name, stop.Customer.Name)
+					s.Stats.droppedOff(driver, now)
+					if trip, ok := s.openTrips.droppedOff(driver.Name, stop.Customer, now); ok {
+						s.saveTrip(trip)
 					}
-					if len(driver.GraphPath) == 0 {
-						fmt.Printf("❌ Still no path for driver %s. Marking as idle.\n", driver.Name)
-						driver.MoveTime = now.Add(2 * time.Second) // Retry later
-						continue
-					}
-
-					driver.PathIndex = 0
+					s.Events.Publish(LiveEvent{Type: EventDropoff, Time: now, Driver: driver.Name, Data: stop.Customer})
+					s.notifyDispatcher() // a seat just freed up
+				}
+				driver.syncStops(now)
+				s.routeToNextStop(driver, now)
+			} else {
+				// Idle roaming
+				dest := getRandomNode(g, rng)
+				driver.GraphPath = aStarGraphCoords(g, driver.Lat, driver.Lon, dest.Lat, dest.Lon)
+				for i := 0; i < 5 && len(driver.GraphPath) == 0; i++ {
+					dest = getRandomNode(g, rng)
+					driver.GraphPath = aStarGraphCoords(g, driver.Lat, driver.Lon, dest.Lat, dest.Lon)
+				}
+				if len(driver.GraphPath) == 0 {
+					fmt.Printf("❌ Still no path for driver %s. Marking as idle.\n", driver.Name)
+					driver.MoveTime = now.Add(2 * time.Second) // Retry later
+					continue
 				}
 
-				// Schedule next move attempt after short delay
-				driver.MoveTime = now.Add(2 * time.Second)
-				driver.ETA = estimateETA(driver.GraphPath)
+				driver.PathIndex = 0
 			}
+
+			// Schedule next move attempt after short delay
+			driver.MoveTime = now.Add(2 * time.Second)
+			oldETA := driver.ETA
+			driver.ETA = estimateETA(g, driver.GraphPath)
+			s.publishRoute(driver, oldETA, now)
+		}
+	}
+
+	var due time.Time
+	for i := range s.Drivers {
+		moveAt := s.Drivers[i].MoveTime
+		if moveAt.After(now) && (due.IsZero() || moveAt.Before(due)) {
+			due = moveAt
 		}
+	}
+	return due
+}
 
-		driverMutex.Unlock()
+// routeToNextStop sends the driver to the head of its stop list, or back to
+// roaming if the list is empty. Callers must hold driverMu.
+func (s *Simulation) routeToNextStop(driver *Driver, now time.Time) {
+	if len(driver.Stops) > 0 {
+		// Head to the next pickup or drop-off
+		next := driver.Stops[0]
+		path := aStarGraphCoords(s.Graph, driver.Lat, driver.Lon, next.Lat, next.Lon)
+		driver.GraphPath = path
+		driver.PathIndex = 0
+		driver.LegStartedAt = now
+		s.addHeat(path)
+		return
 	}
+	// Resume roaming
+	driver.LegStartedAt = time.Time{}
+	dest := getRandomNode(s.Graph, s.Rand)
+	driver.GraphPath = aStarGraphCoords(s.Graph, driver.Lat, driver.Lon, dest.Lat, dest.Lon)
+	driver.PathIndex = 0
 }
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// readOSMPBF streams an .osm.pbf file into c. The format is a sequence of
+// length-prefixed blobs of protocol buffers (see
+// https://wiki.openstreetmap.org/wiki/PBF_Format); this reads the parts the
+// graph needs with a minimal decoder rather than a protobuf dependency.
+func readOSMPBF(r io.Reader, c *osmCollector) error {
+	for n := 0; ; n++ {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("blob %d: %w", n, err)
+		}
+		if size > 64*1024 {
+			return fmt.Errorf("blob %d: header of %d bytes is too big", n, size)
+		}
+		header := make([]byte, size)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return fmt.Errorf("blob %d: %w", n, err)
+		}
+		kind, dataSize, err := parseBlobHeader(header)
+		if err != nil {
+			return fmt.Errorf("blob %d: %w", n, err)
+		}
+		if dataSize > 32*1024*1024 {
+			return fmt.Errorf("blob %d: %d bytes is too big", n, dataSize)
+		}
+		blob := make([]byte, dataSize)
+		if _, err := io.ReadFull(r, blob); err != nil {
+			return fmt.Errorf("blob %d: %w", n, err)
+		}
+
+		switch kind {
+		case "OSMHeader", "OSMData":
+			data, err := unpackBlob(blob)
+			if err != nil {
+				return fmt.Errorf("blob %d: %w", n, err)
+			}
+			if kind == "OSMHeader" {
+				err = checkPBFHeader(data)
+			} else {
+				err = readPrimitiveBlock(data, c)
+			}
+			if err != nil {
+				return fmt.Errorf("blob %d: %w", n, err)
+			}
+		}
+		// Other blob types are allowed and skipped
+	}
+}
+
+func parseBlobHeader(data []byte) (kind string, size int, err error) {
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return "", 0, err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			b, err := p.bytes()
+			if err != nil {
+				return "", 0, err
+			}
+			kind = string(b)
+		case field == 3 && wire == wireVarint:
+			v, err := p.varint()
+			if err != nil {
+				return "", 0, err
+			}
+			size = int(v)
+		default:
+			if err := p.skip(wire); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+	return kind, size, nil
+}
+
+// unpackBlob returns a blob's contents, inflating them if they are
+// zlib-compressed. Other compressions are rare and not supported.
+func unpackBlob(data []byte) ([]byte, error) {
+	p := protoBuf(data)
+	rawSize := 0
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			return p.bytes()
+		case field == 2 && wire == wireVarint:
+			v, err := p.varint()
+			if err != nil {
+				return nil, err
+			}
+			rawSize = int(v)
+		case field == 3 && wire == wireBytes:
+			compressed, err := p.bytes()
+			if err != nil {
+				return nil, err
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			var out bytes.Buffer
+			out.Grow(rawSize)
+			if _, err := io.Copy(&out, zr); err != nil {
+				return nil, err
+			}
+			return out.Bytes(), nil
+		case field >= 4 && field <= 7:
+			return nil, fmt.Errorf("blob compression %d is not supported; recompress with zlib, e.g. osmium cat -o out.osm.pbf", field)
+		default:
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, errors.New("blob has no data")
+}
+
+// checkPBFHeader refuses files that need features this reader lacks.
+func checkPBFHeader(data []byte) error {
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		if field != 4 || wire != wireBytes {
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+		feature, err := p.bytes()
+		if err != nil {
+			return err
+		}
+		switch string(feature) {
+		case "OsmSchema-V0.6", "DenseNodes":
+		default:
+			return fmt.Errorf("file needs unsupported feature %q", feature)
+		}
+	}
+	return nil
+}
+
+// primitiveBlock is the context a block's nodes, ways and relations are
+// decoded in.
+type primitiveBlock struct {
+	strings     []string
+	granularity int64 // nanodegrees
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (b *primitiveBlock) coord(offset, v int64) float64 {
+	return float64(offset+b.granularity*v) / 1e9
+}
+
+func (b *primitiveBlock) tags(keys, vals []uint64) (map[string]string, error) {
+	tags := make(map[string]string, len(keys))
+	for i, k := range keys {
+		if i >= len(vals) || k >= uint64(len(b.strings)) || vals[i] >= uint64(len(b.strings)) {
+			return nil, errors.New("tag outside the string table")
+		}
+		tags[b.strings[k]] = b.strings[vals[i]]
+	}
+	return tags, nil
+}
+
+func readPrimitiveBlock(data []byte, c *osmCollector) error {
+	block := primitiveBlock{granularity: 100}
+	var groups [][]byte
+
+	// Groups may come before the fields they depend on, so read those first
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			table, err := p.bytes()
+			if err != nil {
+				return err
+			}
+			if block.strings, err = readStringTable(table); err != nil {
+				return err
+			}
+		case field == 2 && wire == wireBytes:
+			group, err := p.bytes()
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		case (field == 17 || field == 19 || field == 20) && wire == wireVarint:
+			v, err := p.varint()
+			if err != nil {
+				return err
+			}
+			switch field {
+			case 17:
+				block.granularity = int64(v)
+			case 19:
+				block.latOffset = int64(v)
+			case 20:
+				block.lonOffset = int64(v)
+			}
+		default:
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, group := range groups {
+		if err := block.readGroup(group, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readStringTable(data []byte) ([]string, error) {
+	var out []string
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 || wire != wireBytes {
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s, err := p.bytes()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, string(s))
+	}
+	return out, nil
+}
+
+func (b *primitiveBlock) readGroup(data []byte, c *osmCollector) error {
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		if wire != wireBytes || field < 1 || field > 4 {
+			// Changesets play no part in the road graph
+			if err := p.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+		msg, err := p.bytes()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			err = b.readNode(msg, c)
+		case 2:
+			err = b.readDenseNodes(msg, c)
+		case 3:
+			err = b.readWay(msg, c)
+		case 4:
+			err = b.readRelation(msg, c)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *primitiveBlock) readNode(data []byte, c *osmCollector) error {
+	var id, lat, lon int64
+	var keys, vals []uint64
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1, 8, 9:
+			v, err := p.varint()
+			if err != nil {
+				return err
+			}
+			switch field {
+			case 1:
+				id = unzigzag(v)
+			case 8:
+				lat = unzigzag(v)
+			case 9:
+				lon = unzigzag(v)
+			}
+		case 2:
+			keys, err = p.repeated(wire, keys)
+		case 3:
+			vals, err = p.repeated(wire, vals)
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("node %d: %w", id, err)
+	}
+	c.addNode(id, b.coord(b.latOffset, lat), b.coord(b.lonOffset, lon), tags)
+	return nil
+}
+
+// readDenseNodes reads nodes packed column by column, IDs and coordinates as
+// deltas from the node before.
+func (b *primitiveBlock) readDenseNodes(data []byte, c *osmCollector) error {
+	var ids, lats, lons, keysVals []uint64
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			ids, err = p.repeated(wire, ids)
+		case 8:
+			lats, err = p.repeated(wire, lats)
+		case 9:
+			lons, err = p.repeated(wire, lons)
+		case 10:
+			keysVals, err = p.repeated(wire, keysVals)
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return errors.New("dense nodes: ids, lats and lons differ in length")
+	}
+
+	var id, lat, lon int64
+	kv := 0
+	for i := range ids {
+		id += unzigzag(ids[i])
+		lat += unzigzag(lats[i])
+		lon += unzigzag(lons[i])
+
+		// keys_vals holds each node's key, value pairs ended by a 0
+		var keys, vals []uint64
+		for kv < len(keysVals) && keysVals[kv] != 0 {
+			if kv+1 >= len(keysVals) {
+				return fmt.Errorf("node %d: key without a value", id)
+			}
+			keys = append(keys, keysVals[kv])
+			vals = append(vals, keysVals[kv+1])
+			kv += 2
+		}
+		kv++
+		tags, err := b.tags(keys, vals)
+		if err != nil {
+			return fmt.Errorf("node %d: %w", id, err)
+		}
+		c.addNode(id, b.coord(b.latOffset, lat), b.coord(b.lonOffset, lon), tags)
+	}
+	return nil
+}
+
+func (b *primitiveBlock) readWay(data []byte, c *osmCollector) error {
+	var id int64
+	var keys, vals, deltas []uint64
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			var v uint64
+			v, err = p.varint()
+			id = int64(v)
+		case 2:
+			keys, err = p.repeated(wire, keys)
+		case 3:
+			vals, err = p.repeated(wire, vals)
+		case 8:
+			deltas, err = p.repeated(wire, deltas)
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("way %d: %w", id, err)
+	}
+	refs := make([]int64, len(deltas))
+	var ref int64
+	for i, d := range deltas {
+		ref += unzigzag(d)
+		refs[i] = ref
+	}
+	c.addWay(id, refs, tags)
+	return nil
+}
+
+var pbfMemberTypes = []string{"node", "way", "relation"}
+
+func (b *primitiveBlock) readRelation(data []byte, c *osmCollector) error {
+	var id int64
+	var keys, vals, roles, deltas, types []uint64
+	p := protoBuf(data)
+	for !p.done() {
+		field, wire, err := p.key()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			var v uint64
+			v, err = p.varint()
+			id = int64(v)
+		case 2:
+			keys, err = p.repeated(wire, keys)
+		case 3:
+			vals, err = p.repeated(wire, vals)
+		case 8:
+			roles, err = p.repeated(wire, roles)
+		case 9:
+			deltas, err = p.repeated(wire, deltas)
+		case 10:
+			types, err = p.repeated(wire, types)
+		default:
+			err = p.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tags, err := b.tags(keys, vals)
+	if err != nil {
+		return fmt.Errorf("relation %d: %w", id, err)
+	}
+	if tags["type"] != "restriction" {
+		return nil
+	}
+	if len(roles) != len(deltas) || len(types) != len(deltas) {
+		return fmt.Errorf("relation %d: member lists of different lengths", id)
+	}
+	members := make([]osmMember, len(deltas))
+	var ref int64
+	for i, d := range deltas {
+		ref += unzigzag(d)
+		if roles[i] >= uint64(len(b.strings)) || types[i] >= uint64(len(pbfMemberTypes)) {
+			return fmt.Errorf("relation %d: bad member %d", id, i)
+		}
+		members[i] = osmMember{Type: pbfMemberTypes[types[i]], Ref: ref, Role: b.strings[roles[i]]}
+	}
+	c.addRelation(members, tags)
+	return nil
+}
+
+// Protocol buffer wire types.
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+var errTruncated = errors.New("truncated protocol buffer")
+
+// protoBuf reads protocol buffer fields off the front of a message.
+type protoBuf []byte
+
+func (p *protoBuf) done() bool { return len(*p) == 0 }
+
+func (p *protoBuf) varint() (uint64, error) {
+	v, n := binary.Uvarint(*p)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	*p = (*p)[n:]
+	return v, nil
+}
+
+func (p *protoBuf) key() (field, wire int, err error) {
+	v, err := p.varint()
+	return int(v >> 3), int(v & 7), err
+}
+
+func (p *protoBuf) bytes() ([]byte, error) {
+	n, err := p.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(*p)) {
+		return nil, errTruncated
+	}
+	b := (*p)[:n]
+	*p = (*p)[n:]
+	return b, nil
+}
+
+func (p *protoBuf) skip(wire int) error {
+	var n int
+	switch wire {
+	case wireVarint:
+		_, err := p.varint()
+		return err
+	case wireBytes:
+		_, err := p.bytes()
+		return err
+	case wire64:
+		n = 8
+	case wire32:
+		n = 4
+	default:
+		return fmt.Errorf("unsupported wire type %d", wire)
+	}
+	if n > len(*p) {
+		return errTruncated
+	}
+	*p = (*p)[n:]
+	return nil
+}
+
+// repeated appends a repeated varint field to out, whether it was written
+// packed or one value at a time.
+func (p *protoBuf) repeated(wire int, out []uint64) ([]uint64, error) {
+	if wire == wireVarint {
+		v, err := p.varint()
+		return append(out, v), err
+	}
+	if wire != wireBytes {
+		return out, fmt.Errorf("unexpected wire type %d for a repeated field", wire)
+	}
+	packed, err := p.bytes()
+	if err != nil {
+		return out, err
+	}
+	q := protoBuf(packed)
+	for !q.done() {
+		v, err := q.varint()
+		if err != nil {
+			return out, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
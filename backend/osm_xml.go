@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// readOSMXML streams an OSM XML file into c.
+func readOSMXML(r io.Reader, c *osmCollector) error {
+	dec := xml.NewDecoder(r)
+	var (
+		inNode, inWay, inRelation bool
+		id                        int64
+		lat, lon                  float64
+		refs                      []int64
+		members                   []osmMember
+		tags                      map[string]string
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			switch t.Name.Local {
+			case "node":
+				inNode, tags = true, map[string]string{}
+				if id, err = strconv.ParseInt(attrs["id"], 10, 64); err == nil {
+					if lat, err = strconv.ParseFloat(attrs["lat"], 64); err == nil {
+						lon, err = strconv.ParseFloat(attrs["lon"], 64)
+					}
+				}
+				if err != nil {
+					return fmt.Errorf("line %d: node: %w", lineOf(dec), err)
+				}
+			case "way":
+				inWay, refs, tags = true, nil, map[string]string{}
+				if id, err = strconv.ParseInt(attrs["id"], 10, 64); err != nil {
+					return fmt.Errorf("line %d: way: %w", lineOf(dec), err)
+				}
+			case "relation":
+				inRelation, members, tags = true, nil, map[string]string{}
+			case "member":
+				if !inRelation {
+					continue
+				}
+				ref, err := strconv.ParseInt(attrs["ref"], 10, 64)
+				if err != nil {
+					return fmt.Errorf("line %d: member: %w", lineOf(dec), err)
+				}
+				members = append(members, osmMember{Type: attrs["type"], Ref: ref, Role: attrs["role"]})
+			case "nd":
+				if !inWay {
+					continue
+				}
+				ref, err := strconv.ParseInt(attrs["ref"], 10, 64)
+				if err != nil {
+					return fmt.Errorf("line %d: nd: %w", lineOf(dec), err)
+				}
+				refs = append(refs, ref)
+			case "tag":
+				if inNode || inWay || inRelation {
+					tags[attrs["k"]] = attrs["v"]
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "node":
+				c.addNode(id, lat, lon, tags)
+				inNode = false
+			case "way":
+				c.addWay(id, refs, tags)
+				inWay = false
+			case "relation":
+				c.addRelation(members, tags)
+				inRelation = false
+			}
+		}
+	}
+}
+
+func lineOf(dec *xml.Decoder) int {
+	line, _ := dec.InputPos()
+	return line
+}
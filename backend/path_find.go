@@ -1,89 +1,185 @@
 package main
 
 import (
-	"log"
-	"math"
-	"math/rand"
-	"strconv"
+	"container/heap"
+	"sync"
 )
 
-func aStarGraph(startID, endID string) []GraphNode {
-	openSet := map[string]*PathNode{}
-	closedSet := map[string]bool{}
+// openItem is an entry in the A* open list. Searches run over edges rather
+// than nodes, each standing for a vehicle that has just driven along it, so
+// the turn onto the next edge can be priced and checked against turn rules.
+// Entries are never updated in place; a cheaper route pushes a new entry and
+// the stale one is skipped on pop.
+type openItem struct {
+	edge int32
+	f    float64
+}
+
+type openList []openItem
+
+func (o openList) Len() int            { return len(o) }
+func (o openList) Less(i, j int) bool  { return o[i].f < o[j].f }
+func (o openList) Swap(i, j int)       { o[i], o[j] = o[j], o[i] }
+func (o *openList) Push(x interface{}) { *o = append(*o, x.(openItem)) }
+func (o *openList) Pop() interface{} {
+	old := *o
+	item := old[len(old)-1]
+	*o = old[:len(old)-1]
+	return item
+}
+
+// searchState holds the per-edge scratch arrays for one search, and the
+// heuristic of each node it has reached. Entries are only valid when their
+// seen stamp matches stamp, so the arrays never need clearing between
+// searches.
+type searchState struct {
+	stamp  uint32
+	seen   []uint32
+	closed []uint32
+	g      []float64
+	parent []int32
+	hSeen  []uint32 // per node
+	h      []float64
+	open   openList
+}
+
+var searchPool sync.Pool
+
+func acquireSearch(g *RoadGraph) *searchState {
+	m, n := len(g.EdgeTo), g.NumNodes()
+	s, _ := searchPool.Get().(*searchState)
+	if s == nil || len(s.seen) != m || len(s.hSeen) != n {
+		s = &searchState{
+			seen:   make([]uint32, m),
+			closed: make([]uint32, m),
+			g:      make([]float64, m),
+			parent: make([]int32, m),
+			hSeen:  make([]uint32, n),
+			h:      make([]float64, n),
+		}
+	}
+	s.stamp++
+	if s.stamp == 0 { // wrapped around, stale stamps could match again
+		for i := range s.seen {
+			s.seen[i], s.closed[i] = 0, 0
+		}
+		for i := range s.hSeen {
+			s.hSeen[i] = 0
+		}
+		s.stamp = 1
+	}
+	s.open = s.open[:0]
+	return s
+}
 
-	start := graph[startID]
-	goal := graph[endID]
+func (s *searchState) visit(edge, parent int32, g float64) {
+	s.seen[edge] = s.stamp
+	s.g[edge] = g
+	s.parent[edge] = parent
+}
 
-	startNode := &PathNode{ID: start.ID, G: 0, F: heuristic(start, goal)}
-	openSet[strconv.Itoa(start.ID)] = startNode
+// estimate returns h(node), working it out once per search.
+func (s *searchState) estimate(node int32, h func(int32) float64) float64 {
+	if s.hSeen[node] != s.stamp {
+		s.hSeen[node] = s.stamp
+		s.h[node] = h(node)
+	}
+	return s.h[node]
+}
 
-	for len(openSet) > 0 {
-		var current *PathNode
-		for _, node := range openSet {
-			if current == nil || node.F < current.F {
-				current = node
-			}
+// relax pushes every edge a vehicle that has just driven along edge e may
+// turn onto. h estimates the cost left from the node an edge arrives at.
+func (s *searchState) relax(g *RoadGraph, model CostModel, e int32, h func(int32) float64) {
+	lo, hi := g.Edges(g.EdgeTo[e])
+	for next := lo; next < hi; next++ {
+		if s.closed[next] == s.stamp {
+			continue
+		}
+		seconds, ok := g.Turn(e, next)
+		if !ok {
+			continue
+		}
+		tentativeG := s.g[e] + model.TurnCost(g, seconds) + model.EdgeCost(g, next)
+		if s.seen[next] != s.stamp || tentativeG < s.g[next] {
+			s.visit(next, e, tentativeG)
+			heap.Push(&s.open, openItem{edge: next, f: tentativeG + s.estimate(g.EdgeTo[next], h)})
 		}
+	}
+}
+
+// routeCost is the cost model used by aStarGraph and everything built on it.
+var routeCost CostModel = TravelTimeCost{}
+
+func aStarGraph(startID, endID string) []GraphNode {
+	return aStarGraphWith(routeCost, startID, endID)
+}
+
+func aStarGraphWith(model CostModel, startID, endID string) []GraphNode {
+	g := roadGraph
+	start, ok := g.Lookup(startID)
+	if !ok {
+		return nil
+	}
+	goal, ok := g.Lookup(endID)
+	if !ok {
+		return nil
+	}
+	return aStarIndexed(g, model, start, goal)
+}
 
-		if strconv.Itoa(current.ID) == endID {
-			return reconstructPath(current)
+func aStarIndexed(g *RoadGraph, model CostModel, start, goal int32) []GraphNode {
+	if start == goal {
+		return []GraphNode{g.Node(start)}
+	}
+	if ch := g.CH; ch != nil && ch.Model == model {
+		states, _ := ch.route(ch.nodeEnds(g, model, start, goal))
+		if states == nil {
+			return nil
 		}
+		return append([]GraphNode{g.Node(start)}, statePath(g, states)...)
+	}
+	s := acquireSearch(g)
+	defer searchPool.Put(s)
+
+	h := func(n int32) float64 { return heuristic(g, model, n, goal) }
+	lo, hi := g.Edges(start)
+	for e := lo; e < hi; e++ {
+		s.visit(e, -1, model.EdgeCost(g, e))
+		heap.Push(&s.open, openItem{edge: e, f: s.g[e] + s.estimate(g.EdgeTo[e], h)})
+	}
 
-		delete(openSet, strconv.Itoa(current.ID))
-		closedSet[strconv.Itoa(current.ID)] = true
-
-		for neighborIDStr, info := range graph[strconv.Itoa(current.ID)].Neighbors {
-			if closedSet[neighborIDStr] {
-				continue
-			}
-
-			// Default speed fallback if missing or 0
-			speed := info.Speed
-			if speed <= 0 {
-				speed = 40.0 // Default speed in km/h
-			}
-
-			// Compute time cost (in hours)
-			timeCost := info.Distance / (speed * 1000.0 / 3600.0) // convert to seconds
-
-			delayPenalty := 0.0
-
-			node := graph[neighborIDStr]
-			if node.TrafficLight {
-				// Only stop at ~1 in 3 lights (simulate catching green)
-				if rand.Float64() < 0.33 {
-					delayPenalty += 15.0 // seconds
-				}
-			} else if node.StopSign {
-				delayPenalty += 1.0 // seconds, soft penalty for ETA only
-			}
-
-			tentativeG := current.G + timeCost + delayPenalty
-
-			neighborID, err := strconv.Atoi(neighborIDStr)
-			if err != nil {
-				log.Printf("Invalid neighbor ID: %s", neighborIDStr)
-				continue
-			}
-
-			neighbor, exists := openSet[neighborIDStr]
-			if !exists || tentativeG < neighbor.G {
-				newNode := &PathNode{
-					ID:     neighborID,
-					G:      tentativeG,
-					F:      tentativeG + heuristic(graph[neighborIDStr], goal),
-					Parent: current,
-				}
-				openSet[neighborIDStr] = newNode
-			}
+	for s.open.Len() > 0 {
+		current := heap.Pop(&s.open).(openItem).edge
+		if s.closed[current] == s.stamp {
+			continue
+		}
+		if g.EdgeTo[current] == goal {
+			return append([]GraphNode{g.Node(start)}, reconstructPath(g, s.parent, current)...)
 		}
+		s.closed[current] = s.stamp
+		s.relax(g, model, current, h)
 	}
 	return nil
 }
 
-func heuristic(a, b GraphNode) float64 {
-	// Use Euclidean or Haversine distance
-	dLat := a.Lat - b.Lat
-	dLon := a.Lon - b.Lon
-	return math.Sqrt(dLat*dLat + dLon*dLon)
+// reconstructPath lists the nodes the chain of edges ending at end arrives
+// at, first to last. The node the first edge leaves is not included.
+func reconstructPath(g *RoadGraph, parent []int32, end int32) []GraphNode {
+	length := 0
+	for e := end; e != -1; e = parent[e] {
+		length++
+	}
+	path := make([]GraphNode, length)
+	for e := end; e != -1; e = parent[e] {
+		length--
+		path[length] = g.Node(g.EdgeTo[e])
+	}
+	return path
+}
+
+// heuristic is admissible and consistent for any CostModel that honours the
+// LowerBound contract: the stretched haversine never exceeds real road length,
+// and turns only ever add to a route's cost.
+func heuristic(g *RoadGraph, model CostModel, a, b int32) float64 {
+	return model.LowerBound(g, g.straightLine(a, g.Lat.At(b), g.Lon.At(b)))
 }
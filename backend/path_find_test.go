@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// mapScanNode is an open-list entry of mapScanAStar.
+type mapScanNode struct {
+	ID     int
+	G, F   float64
+	Parent *mapScanNode
+}
+
+// mapScanAStar is the search aStarGraph replaced, kept to benchmark against:
+// it works on string-keyed node maps and finds the best open node by
+// scanning the whole open set. Lights cost their expected delay, as they do
+// now, rather than a random one.
+func mapScanAStar(graph map[string]GraphNode, startID, endID string) []GraphNode {
+	openSet := map[string]*mapScanNode{}
+	closedSet := map[string]bool{}
+
+	start := graph[startID]
+	goal := graph[endID]
+	heuristic := func(a, b GraphNode) float64 {
+		dLat := a.Lat - b.Lat
+		dLon := a.Lon - b.Lon
+		return math.Sqrt(dLat*dLat + dLon*dLon)
+	}
+	openSet[startID] = &mapScanNode{ID: start.ID, F: heuristic(start, goal)}
+
+	for len(openSet) > 0 {
+		var current *mapScanNode
+		for _, node := range openSet {
+			if current == nil || node.F < current.F {
+				current = node
+			}
+		}
+		currentID := strconv.Itoa(current.ID)
+		if currentID == endID {
+			var path []GraphNode
+			for n := current; n != nil; n = n.Parent {
+				path = append([]GraphNode{graph[strconv.Itoa(n.ID)]}, path...)
+			}
+			return path
+		}
+		delete(openSet, currentID)
+		closedSet[currentID] = true
+
+		for neighborIDStr, info := range graph[currentID].Neighbors {
+			if closedSet[neighborIDStr] {
+				continue
+			}
+			speed := info.Speed
+			if speed <= 0 {
+				speed = defaultSpeed
+			}
+			tentativeG := current.G + info.Distance/(speed*1000.0/3600.0)
+			node := graph[neighborIDStr]
+			if node.TrafficLight {
+				tentativeG += 0.33 * 15.0
+			} else if node.StopSign {
+				tentativeG += 1.0
+			}
+			neighbor, exists := openSet[neighborIDStr]
+			if !exists || tentativeG < neighbor.G {
+				neighborID, _ := strconv.Atoi(neighborIDStr)
+				openSet[neighborIDStr] = &mapScanNode{
+					ID:     neighborID,
+					G:      tentativeG,
+					F:      tentativeG + heuristic(node, goal),
+					Parent: current,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// BenchmarkAStar routes between seeded random nodes of a 120×120 grid with
+// the indexed search and with the map-scan search it replaced.
+func BenchmarkAStar(b *testing.B) {
+	nodes := testGridNodes(120, 120)
+	g := buildRoadGraph(nodes)
+	rng := rand.New(rand.NewSource(1))
+	pairs := make([][2]int32, 64)
+	for i := range pairs {
+		pairs[i] = [2]int32{int32(rng.Intn(g.NumNodes())), int32(rng.Intn(g.NumNodes()))}
+	}
+
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := pairs[i%len(pairs)]
+			if aStarIndexed(g, routeCost, p[0], p[1]) == nil {
+				b.Fatal("no route")
+			}
+		}
+	})
+	b.Run("map-scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := pairs[i%len(pairs)]
+			if mapScanAStar(nodes, g.Key(p[0]), g.Key(p[1])) == nil {
+				b.Fatal("no route")
+			}
+		}
+	})
+}
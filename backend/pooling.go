@@ -0,0 +1,206 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	StopPickup  = "pickup"
+	StopDropoff = "dropoff"
+)
+
+// Stop is one place a driver has promised to visit.
+type Stop struct {
+	Kind     string   `json:"kind"` // StopPickup or StopDropoff
+	Customer Customer `json:"customer"`
+	Lat      float64  `json:"lat"`
+	Lon      float64  `json:"lon"`
+}
+
+func pickupStop(c Customer) Stop {
+	return Stop{Kind: StopPickup, Customer: c, Lat: c.Lat, Lon: c.Lon}
+}
+
+func dropoffStop(c Customer) Stop {
+	return Stop{Kind: StopDropoff, Customer: c, Lat: c.DestinationLat, Lon: c.DestinationLon}
+}
+
+// defaultMaxDetour bounds how much later any rider already on a driver's
+// route may arrive because another customer was squeezed in, and how much
+// longer than a direct trip the new customer may ride.
+const defaultMaxDetour = 10 * time.Minute
+
+// riders counts customers the driver is committed to, on board or not.
+func (d *Driver) riders() int {
+	n := 0
+	for _, s := range d.Stops {
+		if s.Kind == StopDropoff {
+			n++
+		}
+	}
+	return n
+}
+
+// canTakeCustomer reports whether dispatch may offer the driver another
+// customer: any idle driver, or a pooling driver with a free seat.
+func (d *Driver) canTakeCustomer() bool {
+	if len(d.Stops) == 0 {
+		return !d.HasCustomer
+	}
+	return d.Capacity > 1 && d.riders() < d.Capacity
+}
+
+// syncStops keeps the single-customer fields the frontend reads in step with
+// the head of the stop list, and tells a customer whose pickup is now next
+// that the driver is on the way.
+func (d *Driver) syncStops(now time.Time) {
+	if len(d.Stops) == 0 {
+		d.HasCustomer = false
+		d.Customer = Customer{}
+		d.OnPickupLeg = false
+		return
+	}
+	if d.Stops[0].Kind == StopPickup {
+		d.setRiderStatus(d.Stops[0].Customer.Id, CustomerDriverArriving, now)
+	}
+	next := d.Stops[0]
+	d.HasCustomer = true
+	d.Customer = next.Customer
+	d.OnPickupLeg = next.Kind == StopPickup
+	d.DestLat = next.Lat
+	d.DestLon = next.Lon
+}
+
+type leg struct {
+	path    []GraphNode
+	minutes float64 // +Inf when there is no route
+}
+
+// legTimer memoises road routes between points while one insertion is priced.
+type legTimer struct {
+	g    *RoadGraph
+	legs map[[4]float64]leg
+}
+
+func newLegTimer(g *RoadGraph) legTimer {
+	return legTimer{g: g, legs: map[[4]float64]leg{}}
+}
+
+func (t legTimer) route(aLat, aLon, bLat, bLon float64) leg {
+	key := [4]float64{aLat, aLon, bLat, bLon}
+	if l, ok := t.legs[key]; ok {
+		return l
+	}
+	l := leg{minutes: math.Inf(1)}
+	if l.path = aStarGraphCoords(t.g, aLat, aLon, bLat, bLon); len(l.path) > 0 {
+		l.minutes = estimateETA(t.g, l.path)
+	}
+	t.legs[key] = l
+	return l
+}
+
+func (t legTimer) minutes(aLat, aLon, bLat, bLon float64) float64 {
+	return t.route(aLat, aLon, bLat, bLon).minutes
+}
+
+// arrivals returns the minutes from now at which the driver reaches each
+// stop in order, starting from its current position.
+func (t legTimer) arrivals(d *Driver, stops []Stop) []float64 {
+	out := make([]float64, len(stops))
+	lat, lon, at := d.Lat, d.Lon, 0.0
+	for i, s := range stops {
+		at += t.minutes(lat, lon, s.Lat, s.Lon)
+		out[i] = at
+		lat, lon = s.Lat, s.Lon
+	}
+	return out
+}
+
+// bestInsertion finds where to add c's pickup and drop-off to the driver's
+// route so that c is picked up soonest without breaking capacity or delaying
+// anyone already on the route by more than maxDetour. It returns the new
+// stop list and the minutes until c's pickup.
+func bestInsertion(d *Driver, c Customer, t legTimer, maxDetour time.Duration) ([]Stop, float64, bool) {
+	if len(d.Stops) == 0 {
+		stops := []Stop{pickupStop(c), dropoffStop(c)}
+		eta := t.minutes(d.Lat, d.Lon, c.Lat, c.Lon)
+		return stops, eta, !math.IsInf(eta, 1)
+	}
+
+	before := t.arrivals(d, d.Stops)
+	direct := t.minutes(c.Lat, c.Lon, c.DestinationLat, c.DestinationLon)
+	maxDelay := maxDetour.Minutes()
+
+	// Riders already in the car hold a seat until their drop-off
+	onboard := d.riders()
+	for _, s := range d.Stops {
+		if s.Kind == StopPickup {
+			onboard--
+		}
+	}
+
+	var best []Stop
+	bestETA := math.Inf(1)
+	n := len(d.Stops)
+	for i := 0; i <= n; i++ {
+		for j := i; j <= n; j++ {
+			stops := make([]Stop, 0, n+2)
+			stops = append(stops, d.Stops[:i]...)
+			stops = append(stops, pickupStop(c))
+			stops = append(stops, d.Stops[i:j]...)
+			stops = append(stops, dropoffStop(c))
+			stops = append(stops, d.Stops[j:]...)
+
+			if !fitsCapacity(stops, onboard, d.Capacity) {
+				continue
+			}
+			after := t.arrivals(d, stops)
+			pickupAt, dropoffAt := after[i], after[j+1]
+			if math.IsInf(dropoffAt, 1) || pickupAt >= bestETA {
+				continue
+			}
+			if dropoffAt-pickupAt > direct+maxDelay {
+				continue
+			}
+			if delaysExisting(before, after, i, j, maxDelay) {
+				continue
+			}
+			best, bestETA = stops, pickupAt
+		}
+	}
+	return best, bestETA, best != nil
+}
+
+func fitsCapacity(stops []Stop, onboard, capacity int) bool {
+	for _, s := range stops {
+		if s.Kind == StopPickup {
+			onboard++
+		} else {
+			onboard--
+		}
+		if onboard > capacity {
+			return false
+		}
+	}
+	return true
+}
+
+// delaysExisting reports whether any original stop arrives more than
+// maxDelay minutes later in the new plan. after has the new pickup at i and
+// the new drop-off at j+1, so original stop k sits at k, k+1 or k+2.
+func delaysExisting(before, after []float64, i, j int, maxDelay float64) bool {
+	for k := range before {
+		shifted := k
+		if k >= i {
+			shifted++
+		}
+		if k >= j {
+			shifted++
+		}
+		if after[shifted]-before[k] > maxDelay {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// gridRider is a customer riding from node from to node to of g.
+func gridRider(g *RoadGraph, id int, from, to int32) Customer {
+	return Customer{Id: id, Lat: g.Lat.At(from), Lon: g.Lon.At(from), DestinationLat: g.Lat.At(to), DestinationLon: g.Lon.At(to)}
+}
+
+// stopOrder names stops like "+1" for customer 1's pickup and "-1" for the
+// drop-off.
+func stopOrder(stops []Stop) []string {
+	out := make([]string, len(stops))
+	for i, s := range stops {
+		sign := "+"
+		if s.Kind == StopDropoff {
+			sign = "-"
+		}
+		out[i] = sign + string(rune('0'+s.Customer.Id))
+	}
+	return out
+}
+
+func sameOrder(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkInsertion checks what bestInsertion promises about stops: the
+// driver's stops keep their order, no one already on the route arrives more
+// than maxDetour later, and the new customer rides at most maxDetour longer
+// than a direct trip.
+func checkInsertion(t *testing.T, name string, g *RoadGraph, d *Driver, c Customer, stops []Stop, maxDetour time.Duration) {
+	t.Helper()
+	timer := newLegTimer(g)
+	before, after := timer.arrivals(d, d.Stops), timer.arrivals(d, stops)
+	k, pickup, dropoff := 0, -1, -1
+	for i, s := range stops {
+		switch {
+		case s.Customer.Id == c.Id && s.Kind == StopPickup:
+			pickup = i
+		case s.Customer.Id == c.Id:
+			dropoff = i
+		case k < len(d.Stops) && s.Kind == d.Stops[k].Kind && s.Customer.Id == d.Stops[k].Customer.Id:
+			if late := after[i] - before[k]; late > maxDetour.Minutes()+1e-9 {
+				t.Errorf("%s: stop %d arrives %.2f min later, more than %v", name, k, late, maxDetour)
+			}
+			k++
+		default:
+			t.Errorf("%s: stops %v reorder the route %v", name, stopOrder(stops), stopOrder(d.Stops))
+			return
+		}
+	}
+	if k != len(d.Stops) || pickup < 0 || dropoff < pickup {
+		t.Fatalf("%s: stops %v don't fit customer %d into %v", name, stopOrder(stops), c.Id, stopOrder(d.Stops))
+	}
+	direct := timer.minutes(c.Lat, c.Lon, c.DestinationLat, c.DestinationLon)
+	if ride := after[dropoff] - after[pickup]; ride > direct+maxDetour.Minutes()+1e-9 {
+		t.Errorf("%s: ride of %.2f min is more than %v over the direct %.2f", name, ride, maxDetour, direct)
+	}
+}
+
+func TestBestInsertion(t *testing.T) {
+	// Three rows of ten nodes; the driver starts at the west end of row 0
+	g := testGrid(3, 10)
+	a := gridRider(g, 1, 4, 8)                       // along row 0
+	onboard := gridRider(g, 1, 0, 9)                 // already in the car, to the east end
+	along := gridRider(g, 2, 2, 6)                   // further along row 0, overlapping a
+	aside := gridRider(g, 3, 25, 26)                 // down on row 2
+	waiting := []Stop{pickupStop(a), dropoffStop(a)} // a not picked up yet
+	riding := []Stop{dropoffStop(onboard)}
+	tests := []struct {
+		name      string
+		stops     []Stop
+		capacity  int
+		customer  Customer
+		maxDetour time.Duration
+		want      []string
+	}{
+		{"idle", nil, 2, along, defaultMaxDetour, []string{"+2", "-2"}},
+		{"on the way", waiting, 2, along, defaultMaxDetour, []string{"+2", "-2", "+1", "-1"}},
+		// Dropping 2 off first would make 1 wait too long
+		{"on the way, tight", waiting, 2, along, 30 * time.Second, []string{"+2", "+1", "-2", "-1"}},
+		// Any stop before 1's would hold 1 up
+		{"no detour", waiting, 2, along, 0, []string{"+1", "-1", "+2", "-2"}},
+		{"off the route", riding, 2, aside, defaultMaxDetour, []string{"+3", "-3", "-1"}},
+		{"off the route, too far", riding, 2, aside, time.Minute, []string{"-1", "+3", "-3"}},
+		{"no free seat", riding, 1, along, defaultMaxDetour, []string{"-1", "+2", "-2"}},
+	}
+	for _, tt := range tests {
+		d := &Driver{Lat: g.Lat.At(0), Lon: g.Lon.At(0), Capacity: tt.capacity, Stops: tt.stops}
+		timer := newLegTimer(g)
+		stops, eta, ok := bestInsertion(d, tt.customer, timer, tt.maxDetour)
+		if !ok {
+			t.Errorf("%s: no insertion", tt.name)
+			continue
+		}
+		if got := stopOrder(stops); !sameOrder(got, tt.want) {
+			t.Errorf("%s: stops %v, want %v", tt.name, got, tt.want)
+		}
+		checkInsertion(t, tt.name, g, d, tt.customer, stops, tt.maxDetour)
+		for i, at := range timer.arrivals(d, stops) {
+			if stops[i].Kind == StopPickup && stops[i].Customer.Id == tt.customer.Id && math.Abs(at-eta) > 1e-9 {
+				t.Errorf("%s: ETA %v, but the pickup is reached after %v", tt.name, eta, at)
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// runReplayCommand implements `ridesync replay`: it serves the viewer and
+// plays an event log back through it, as recorded or faster.
+func runReplayCommand(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	logPath := fs.String("log", "", "event log written with -event-log")
+	speed := fs.Float64("speed", 1, "playback speed: 1 is as recorded, 10 is ten times faster, 0 jumps to the end")
+	addr := fs.String("addr", ":8080", "address to serve the viewer on")
+	fs.Parse(args)
+
+	if *logPath == "" {
+		return fmt.Errorf("-log is required")
+	}
+	if *speed < 0 {
+		return fmt.Errorf("-speed must be 0 or positive, got %v", *speed)
+	}
+	segments, err := readEventLog(*logPath)
+	if err != nil {
+		return err
+	}
+	var events []LiveEvent
+	for _, seg := range segments {
+		for _, recorded := range seg.Events {
+			ev, err := recorded.Event()
+			if err != nil {
+				return fmt.Errorf("event log %s: %w", *logPath, err)
+			}
+			events = append(events, ev)
+		}
+	}
+
+	// The replay only needs somewhere to keep state for the handlers; it
+	// never routes, so it has no graph
+	sim, err := NewSimulation(defaultSimID, &RoadGraph{}, SimConfig{Dispatcher: "greedy", Capacity: 1}, nil)
+	if err != nil {
+		return err
+	}
+	sim.Clock = NewEventClock(segments[0].Header.Run.Start)
+	if err := simulations.Add(sim); err != nil {
+		return err
+	}
+
+	http.Handle("/", http.FileServer(http.Dir("frontend/")))
+	http.HandleFunc("/set-grid", replayStarted)
+	http.HandleFunc("/get-heatmap-data", withSim(defaultSimID, handleHeatmapData))
+	http.HandleFunc("/get-cust-que", withSim(defaultSimID, getCustQ))
+	http.HandleFunc("/get-drivers", withSim(defaultSimID, getDrivers))
+	http.HandleFunc("/events", withSim(defaultSimID, streamEvents))
+	http.HandleFunc("/get-customer", replayReadOnly)
+	http.HandleFunc("/get-pairing", replayReadOnly)
+	http.HandleFunc("/cancel-customer", replayReadOnly)
+
+	go sim.replay(events, *speed)
+	fmt.Printf("Replaying %d events from %s at %s\n", len(events), *logPath, *addr)
+	return http.ListenAndServe(*addr, nil)
+}
+
+// replay applies events to the simulation's state and republishes them,
+// spacing them out by their recorded times divided by speed. Speed 0 plays
+// everything at once.
+func (s *Simulation) replay(events []LiveEvent, speed float64) {
+	var last time.Time
+	for _, ev := range events {
+		if speed > 0 && !last.IsZero() {
+			// A server restarted on the same log jumps back or forwards
+			// in time between segments; only wait for time moving on
+			if gap := ev.Time.Sub(last); gap > 0 {
+				time.Sleep(time.Duration(float64(gap) / speed))
+			}
+		}
+		last = ev.Time
+
+		if speed > 0 {
+			ev = retimeAnimation(ev, speed)
+		}
+		s.Clock.SleepUntil(ev.Time)
+		s.applyEvent(ev)
+	}
+	log.Printf("Replay finished after %d events\n", len(events))
+}
+
+// retimeAnimation moves a driver's animation deadline to the wall clock the
+// viewer compares it against.
+func retimeAnimation(ev LiveEvent, speed float64) LiveEvent {
+	wall := func(t time.Time) time.Time {
+		return time.Now().Add(time.Duration(float64(t.Sub(ev.Time)) / speed))
+	}
+	switch data := ev.Data.(type) {
+	case DriverMove:
+		data.AnimationTime = wall(data.AnimationTime)
+		ev.Data = data
+	}
+	return ev
+}
+
+// applyEvent brings the fleet, queue and heatmap up to date with ev and
+// publishes it, under both locks so /events subscribers see a consistent
+// snapshot followed by exactly the events after it.
+func (s *Simulation) applyEvent(ev LiveEvent) {
+	s.driverMu.Lock()
+	defer s.driverMu.Unlock()
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	switch data := ev.Data.(type) {
+	case LiveSnapshot:
+		s.Drivers = data.Drivers
+		s.Queue = data.CustQue
+	case RouteChange:
+		// Viewers get the whole driver, as they do live
+		for i := range s.Drivers {
+			if s.Drivers[i].Name == ev.Driver {
+				driver := &s.Drivers[i]
+				driver.GraphPath = data.Path
+				driver.PathIndex = 0
+				driver.Stops = data.Stops
+				driver.LegStartedAt = data.LegStartedAt
+				driver.MoveTime = data.MoveTime
+				driver.syncStops(ev.Time)
+				if len(data.Stops) > 0 {
+					s.addHeat(data.Path)
+				}
+				ev.Data = *driver
+			}
+		}
+	case DriverMove:
+		for i := range s.Drivers {
+			if s.Drivers[i].Name == ev.Driver {
+				s.Drivers[i].Lat, s.Drivers[i].Lon = data.Lat, data.Lon
+				s.Drivers[i].PathIndex = data.PathIndex
+				s.Drivers[i].CurrentSpeed = data.CurrentSpeed
+				s.Drivers[i].AnimationTime = data.AnimationTime
+			}
+		}
+	case float64:
+		for i := range s.Drivers {
+			if s.Drivers[i].Name == ev.Driver {
+				s.Drivers[i].ETA = data
+			}
+		}
+	case Customer:
+		switch ev.Type {
+		case EventCustomerEnqueued:
+			s.Queue = append(s.Queue, data)
+		case EventCustomerAssigned, EventCustomerCancelled, EventCustomerExpired:
+			for i, c := range s.Queue {
+				if c.Id == data.Id {
+					s.Queue = append(s.Queue[:i], s.Queue[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	s.Events.Publish(ev)
+}
+
+// replayStarted answers the viewer's /set-grid; the log decides the fleet.
+func replayStarted(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+}
+
+// replayReadOnly turns away requests that would change a recorded run.
+func replayReadOnly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.Error(w, "Replaying a recorded run; nothing can be changed", http.StatusConflict)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// RoadGraph is a dense, integer-indexed copy of graph built once by loadGraph.
+// Routing works on indices into these slices instead of string map keys.
+type RoadGraph struct {
+	NodeID       []int // index -> OSM node ID, ascending
+	Lat          floatColumn
+	Lon          floatColumn
+	TrafficLight []bool
+	StopSign     []bool
+
+	// Outgoing edges of node i are EdgeTo[EdgeStart[i]:EdgeStart[i+1]] (CSR layout).
+	EdgeStart []int32
+	EdgeTo    []int32
+	EdgeDist  floatColumn // meters
+	EdgeSpeed floatColumn // km/h, 0 when the source data had none
+
+	// Turn rules, sorted by TurnIn then TurnOut: arriving over edge TurnIn[k],
+	// leaving over TurnOut[k] is banned, the only way on, or takes
+	// TurnSeconds[k], as TurnKind[k] says.
+	TurnIn      []int32
+	TurnOut     []int32
+	TurnKind    []uint8
+	TurnSeconds []float64
+
+	// Derived by prepareTurns for pricing turns
+	EdgeSource  []int32   // edge -> the node it leaves
+	EdgeBearing []float64 // radians, counterclockwise from east
+	Junction    []bool    // nodes where three or more roads meet
+
+	// MaxSpeed is the fastest effective edge speed in km/h. MinStretch is the
+	// smallest ratio of edge length to straight-line distance, capped at 1, so
+	// haversine * MinStretch never exceeds the length of a real route.
+	MaxSpeed   float64
+	MinStretch float64
+
+	Spatial *NodeIndex // covers only Main nodes and the edges between them
+	// Main marks the largest strongly connected component; Spawnable lists
+	// its nodes, the ones drivers and customers may be placed on.
+	Main      []bool
+	Spawnable []int32
+
+	Report GraphReport // what loading found and repaired
+
+	CH *ContractionHierarchy // nil unless build-ch has been run for this graph
+}
+
+// floatColumn holds one value per node or edge. A graph built from JSON
+// keeps float64s; a binary graph keeps the float32s it was written with,
+// straight out of the mapped file, and widens each one as it is read.
+type floatColumn struct {
+	wide   []float64
+	narrow []float32
+}
+
+func (c floatColumn) At(i int32) float64 {
+	if c.narrow != nil {
+		return float64(c.narrow[i])
+	}
+	return c.wide[i]
+}
+
+// Set changes value i. A mapped column is read-only, so the first change
+// copies it.
+func (c *floatColumn) Set(i int32, v float64) {
+	if c.narrow != nil {
+		c.wide = make([]float64, len(c.narrow))
+		for k, x := range c.narrow {
+			c.wide[k] = float64(x)
+		}
+		c.narrow = nil
+	}
+	c.wide[i] = v
+}
+
+func buildRoadGraph(nodes map[string]GraphNode) *RoadGraph {
+	keys := make([]string, 0, len(nodes))
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	// Sort so indices (and therefore tie-breaking in searches) are stable across runs
+	sort.Slice(keys, func(i, j int) bool { return nodes[keys[i]].ID < nodes[keys[j]].ID })
+
+	n := len(keys)
+	g := &RoadGraph{
+		NodeID:       make([]int, n),
+		Lat:          floatColumn{wide: make([]float64, n)},
+		Lon:          floatColumn{wide: make([]float64, n)},
+		TrafficLight: make([]bool, n),
+		StopSign:     make([]bool, n),
+		EdgeStart:    make([]int32, n+1),
+	}
+	index := make(map[string]int32, n)
+	for i, k := range keys {
+		node := nodes[k]
+		g.NodeID[i] = node.ID
+		index[k] = int32(i)
+		g.Lat.wide[i] = node.Lat
+		g.Lon.wide[i] = node.Lon
+		g.TrafficLight[i] = node.TrafficLight
+		g.StopSign[i] = node.StopSign
+	}
+
+	for i, k := range keys {
+		g.EdgeStart[i] = int32(len(g.EdgeTo))
+		targets := make([]int32, 0, len(nodes[k].Neighbors))
+		for nk := range nodes[k].Neighbors {
+			if t, ok := index[nk]; ok { // drop edges to nodes missing from the file
+				targets = append(targets, t)
+			} else {
+				g.Report.DanglingEdges++
+			}
+		}
+		sort.Slice(targets, func(a, b int) bool { return targets[a] < targets[b] })
+		for _, t := range targets {
+			info := nodes[k].Neighbors[keys[t]]
+			g.EdgeTo = append(g.EdgeTo, t)
+			g.EdgeDist.wide = append(g.EdgeDist.wide, info.Distance)
+			g.EdgeSpeed.wide = append(g.EdgeSpeed.wide, info.Speed)
+		}
+	}
+	g.EdgeStart[n] = int32(len(g.EdgeTo))
+	g.addTurnRules(nodes, index)
+	g.prepare()
+	return g
+}
+
+// prepare derives the routing bounds and spatial index from the node and
+// edge arrays. It must run once after they are filled in.
+func (g *RoadGraph) prepare() {
+	g.repairEdges()
+	g.prepareTurns()
+	g.MaxSpeed = defaultSpeed
+	g.MinStretch = 1.0
+	for i := int32(0); i < int32(g.NumNodes()); i++ {
+		lo, hi := g.Edges(i)
+		for e := lo; e < hi; e++ {
+			if speed := g.Speed(e); speed > g.MaxSpeed {
+				g.MaxSpeed = speed
+			}
+			straight := haversine(g.Lat.At(i), g.Lon.At(i), g.Lat.At(g.EdgeTo[e]), g.Lon.At(g.EdgeTo[e]))
+			if straight > 0 && g.EdgeDist.At(e)/straight < g.MinStretch {
+				g.MinStretch = g.EdgeDist.At(e) / straight
+			}
+		}
+	}
+	if g.MinStretch < 0 {
+		g.MinStretch = 0
+	}
+	g.findMainComponent()
+	g.Spatial = buildNodeIndex(g)
+
+	g.Spawnable = g.Spawnable[:0]
+	for i := int32(0); i < int32(g.NumNodes()); i++ {
+		if lo, hi := g.Edges(i); hi > lo && g.Main[i] {
+			g.Spawnable = append(g.Spawnable, i)
+		}
+	}
+}
+
+func (g *RoadGraph) NumNodes() int {
+	return len(g.NodeID)
+}
+
+// Edges returns the half-open range of edge indices leaving node i.
+func (g *RoadGraph) Edges(i int32) (int32, int32) {
+	return g.EdgeStart[i], g.EdgeStart[i+1]
+}
+
+// Speed returns the speed of edge e in km/h, falling back to defaultSpeed.
+func (g *RoadGraph) Speed(e int32) float64 {
+	if g.EdgeSpeed.At(e) <= 0 {
+		return defaultSpeed
+	}
+	return g.EdgeSpeed.At(e)
+}
+
+// straightLine is a lower bound in meters on the road distance from node a to
+// the point (lat, lon).
+func (g *RoadGraph) straightLine(a int32, lat, lon float64) float64 {
+	return haversine(g.Lat.At(a), g.Lon.At(a), lat, lon) * g.MinStretch
+}
+
+// Lookup resolves a graph.json key to its dense index.
+func (g *RoadGraph) Lookup(id string) (int32, bool) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, false
+	}
+	return g.lookupID(n)
+}
+
+// lookupID finds a node by OSM ID; NodeID is sorted, so no map is needed.
+func (g *RoadGraph) lookupID(id int) (int32, bool) {
+	i := sort.SearchInts(g.NodeID, id)
+	if i == len(g.NodeID) || g.NodeID[i] != id {
+		return 0, false
+	}
+	return int32(i), true
+}
+
+func (g *RoadGraph) Key(i int32) string {
+	return strconv.Itoa(g.NodeID[i])
+}
+
+// Node returns the routing-relevant fields of node i. Neighbors is left nil so
+// paths stay small when they are sent to the frontend.
+func (g *RoadGraph) Node(i int32) GraphNode {
+	return GraphNode{
+		ID:           g.NodeID[i],
+		Lat:          g.Lat.At(i),
+		Lon:          g.Lon.At(i),
+		TrafficLight: g.TrafficLight[i],
+		StopSign:     g.StopSign[i],
+	}
+}
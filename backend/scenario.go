@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scenario is a reproducible experiment: the map, the fleet, the demand and
+// the settings to run them with. The server and `ridesync sim` both load one
+// with -scenario; each ignores the fields it has no use for.
+type Scenario struct {
+	Name       string   `json:"name"`
+	Graph      string   `json:"graph"` // relative paths are from the scenario file
+	Seed       *int64   `json:"seed"`
+	Speed      *float64 `json:"speed"`     // server only
+	StartHour  *int     `json:"startHour"` // sim only
+	Duration   string   `json:"duration"`  // sim only, e.g. "8h"
+	Dispatcher string   `json:"dispatcher"`
+	MaxDetour  string   `json:"maxDetour"`
+	Patience   string   `json:"patience"`
+
+	VehicleTypes map[string]VehicleType `json:"vehicleTypes"`
+	Fleet        []FleetEntry           `json:"fleet"`
+
+	Demand     *DemandProfile `json:"demand"`
+	DemandFile string         `json:"demandFile"` // instead of demand
+}
+
+// FleetEntry is one driver, or Count identical ones.
+type FleetEntry struct {
+	Name      string `json:"name"`      // generated if empty; numbered when Count > 1
+	Count     int    `json:"count"`     // 0 means 1
+	Vehicle   string `json:"vehicle"`   // a key of vehicleTypes; empty is the standard vehicle
+	StartNode string `json:"startNode"` // graph node ID, e.g. a depot; empty is a random node
+}
+
+// fieldErrors collects everything wrong with a scenario, each pointed at the
+// field responsible, so one run reports them all.
+type fieldErrors []string
+
+func (e *fieldErrors) add(field, format string, args ...any) {
+	*e = append(*e, field+": "+fmt.Sprintf(format, args...))
+}
+
+func (e fieldErrors) err(path string) error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("scenario %s:\n  %s", path, strings.Join(e, "\n  "))
+}
+
+// loadScenario reads and checks the scenario at path. Checks that need the
+// road graph are left to checkGraph.
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc Scenario
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("scenario %s: %s", path, describeJSONError(data, dec, err))
+	}
+
+	var errs fieldErrors
+	sc.validate(&errs)
+	if err := errs.err(path); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	if sc.Graph != "" && !filepath.IsAbs(sc.Graph) {
+		sc.Graph = filepath.Join(dir, sc.Graph)
+	}
+	if sc.DemandFile != "" {
+		if !filepath.IsAbs(sc.DemandFile) {
+			sc.DemandFile = filepath.Join(dir, sc.DemandFile)
+		}
+		if sc.Demand, err = loadDemandProfile(sc.DemandFile); err != nil {
+			return nil, fmt.Errorf("scenario %s: demandFile: %w", path, err)
+		}
+	}
+	return &sc, nil
+}
+
+// describeJSONError says where in the file decoding failed, and which field
+// was to blame when the decoder knows.
+func describeJSONError(data []byte, dec *json.Decoder, err error) string {
+	offset := dec.InputOffset()
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		offset = syntax.Offset
+	case errors.As(err, &typ):
+		offset = typ.Offset
+		if typ.Field != "" {
+			return fmt.Sprintf("%s: want %s, got %s (line %d)", typ.Field, typ.Type, typ.Value, lineAt(data, offset))
+		}
+	}
+	return fmt.Sprintf("line %d: %s", lineAt(data, offset), strings.TrimPrefix(err.Error(), "json: "))
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func (sc *Scenario) validate(errs *fieldErrors) {
+	if sc.Speed != nil && *sc.Speed < 0 {
+		errs.add("speed", "must be 0 or positive, got %v", *sc.Speed)
+	}
+	if sc.StartHour != nil && (*sc.StartHour < 0 || *sc.StartHour > 23) {
+		errs.add("startHour", "must be between 0 and 23, got %d", *sc.StartHour)
+	}
+	if sc.Duration != "" {
+		if d, err := time.ParseDuration(sc.Duration); err != nil || d <= 0 {
+			errs.add("duration", "want a positive duration like \"8h\", got %q", sc.Duration)
+		}
+	}
+	if sc.Dispatcher != "" {
+		if _, err := newDispatcher(sc.Dispatcher); err != nil {
+			errs.add("dispatcher", "%v", err)
+		}
+	}
+	if sc.MaxDetour != "" {
+		if d, err := time.ParseDuration(sc.MaxDetour); err != nil || d < 0 {
+			errs.add("maxDetour", "want a duration like \"10m\", got %q", sc.MaxDetour)
+		}
+	}
+	if sc.Patience != "" {
+		if d, err := time.ParseDuration(sc.Patience); err != nil || d < 0 {
+			errs.add("patience", "want a duration like \"15m\", got %q", sc.Patience)
+		}
+	}
+
+	types := make([]string, 0, len(sc.VehicleTypes))
+	for name := range sc.VehicleTypes {
+		types = append(types, name)
+	}
+	sort.Strings(types) // report in a stable order
+	for _, name := range types {
+		v := sc.VehicleTypes[name]
+		field := "vehicleTypes." + name
+		if v.Capacity < 1 {
+			errs.add(field+".capacity", "must be at least 1, got %d", v.Capacity)
+		}
+		if v.FuelCapacity <= 0 {
+			errs.add(field+".fuelCapacity", "must be positive, got %v", v.FuelCapacity)
+		}
+		if v.FuelPerKm < 0 {
+			errs.add(field+".fuelPerKm", "must be 0 or positive, got %v", v.FuelPerKm)
+		}
+	}
+
+	for i, entry := range sc.Fleet {
+		field := fmt.Sprintf("fleet[%d]", i)
+		if entry.Count < 0 {
+			errs.add(field+".count", "must be 0 or positive, got %d", entry.Count)
+		}
+		if _, ok := sc.VehicleTypes[entry.Vehicle]; entry.Vehicle != "" && entry.Vehicle != defaultVehicleName && !ok {
+			errs.add(field+".vehicle", "unknown vehicle type %q", entry.Vehicle)
+		}
+	}
+	// Only worth naming drivers once every entry is sound
+	if len(sc.Fleet) > 0 && len(*errs) == 0 {
+		sc.checkNames(errs)
+	}
+
+	if sc.Demand != nil && sc.DemandFile != "" {
+		errs.add("demandFile", "give demand or demandFile, not both")
+	}
+	if sc.Demand != nil {
+		if err := sc.Demand.validate(); err != nil {
+			errs.add("demand", "%v", err)
+		}
+	}
+}
+
+// checkNames reports drivers that would share a name.
+func (sc *Scenario) checkNames(errs *fieldErrors) {
+	owner := map[string]string{}
+	for i, spec := range sc.driverSpecs(1) {
+		field := fmt.Sprintf("fleet[%d].name", sc.entryOf(i))
+		if first, ok := owner[spec.Name]; ok {
+			errs.add(field, "driver %q is already defined by %s", spec.Name, first)
+			continue
+		}
+		owner[spec.Name] = field
+	}
+}
+
+// entryOf returns the fleet entry the i-th expanded driver came from.
+func (sc *Scenario) entryOf(i int) int {
+	for e, entry := range sc.Fleet {
+		n := entry.Count
+		if n == 0 {
+			n = 1
+		}
+		if i < n {
+			return e
+		}
+		i -= n
+	}
+	return len(sc.Fleet) - 1
+}
+
+// driverSpecs expands the fleet into one spec per driver. Drivers without a
+// name take the next from driverNames; the standard vehicle seats capacity.
+func (sc *Scenario) driverSpecs(capacity int) []DriverSpec {
+	var unnamed int
+	for _, entry := range sc.Fleet {
+		if entry.Name == "" {
+			unnamed += entry.Count
+			if entry.Count == 0 {
+				unnamed++
+			}
+		}
+	}
+	generated := fleetNames(unnamed)
+
+	var specs []DriverSpec
+	for _, entry := range sc.Fleet {
+		vehicle, vehicleName := defaultVehicle, entry.Vehicle
+		vehicle.Capacity = capacity
+		if v, ok := sc.VehicleTypes[entry.Vehicle]; ok {
+			vehicle = v
+		}
+		if vehicleName == "" {
+			vehicleName = defaultVehicleName
+		}
+
+		n := entry.Count
+		if n == 0 {
+			n = 1
+		}
+		for k := 1; k <= n; k++ {
+			spec := DriverSpec{Name: entry.Name, Vehicle: vehicleName, VehicleType: vehicle, StartNode: entry.StartNode}
+			switch {
+			case spec.Name == "":
+				spec.Name, generated = generated[0], generated[1:]
+			case n > 1:
+				spec.Name += strconv.Itoa(k)
+			}
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// checkGraph reports start nodes that aren't on g, that no road leaves, or
+// that are outside its main component.
+func (sc *Scenario) checkGraph(g *RoadGraph, path string) error {
+	var errs fieldErrors
+	for i, entry := range sc.Fleet {
+		if entry.StartNode == "" {
+			continue
+		}
+		node, ok := g.Lookup(entry.StartNode)
+		if !ok {
+			errs.add(fmt.Sprintf("fleet[%d].startNode", i), "node %s is not in the graph", entry.StartNode)
+			continue
+		}
+		if lo, hi := g.Edges(node); hi == lo {
+			errs.add(fmt.Sprintf("fleet[%d].startNode", i), "node %s has no roads leaving it", entry.StartNode)
+		} else if !g.Main[node] {
+			errs.add(fmt.Sprintf("fleet[%d].startNode", i), "node %s is on an island cut off from the rest of the map", entry.StartNode)
+		}
+	}
+	return errs.err(path)
+}
+
+// scenarioFlag is a scenario field that stands in for a command-line flag.
+type scenarioFlag struct {
+	flag, field, value string
+}
+
+func (sc *Scenario) flags() []scenarioFlag {
+	var out []scenarioFlag
+	add := func(flagName, field, value string) {
+		if value != "" {
+			out = append(out, scenarioFlag{flagName, field, value})
+		}
+	}
+	add("graph", "graph", sc.Graph)
+	if sc.Seed != nil {
+		add("seed", "seed", strconv.FormatInt(*sc.Seed, 10))
+	}
+	if sc.Speed != nil {
+		add("speed", "speed", strconv.FormatFloat(*sc.Speed, 'g', -1, 64))
+	}
+	if sc.StartHour != nil {
+		add("start-hour", "startHour", strconv.Itoa(*sc.StartHour))
+	}
+	add("duration", "duration", sc.Duration)
+	add("dispatcher", "dispatcher", sc.Dispatcher)
+	add("max-detour", "maxDetour", sc.MaxDetour)
+	add("patience", "patience", sc.Patience)
+	return out
+}
+
+// applyScenario loads the scenario at path into fs's flags. Flags given on
+// the command line win over the scenario, and scenario fields fs has no flag
+// for are ignored. The caller picks up the fleet and demand from the
+// returned scenario.
+func applyScenario(fs *flag.FlagSet, path string) (*Scenario, error) {
+	sc, err := loadScenario(path)
+	if err != nil {
+		return nil, err
+	}
+	var errs fieldErrors
+	for _, f := range sc.flags() {
+		if flagGiven(fs, f.flag) || fs.Lookup(f.flag) == nil {
+			continue
+		}
+		if err := fs.Set(f.flag, f.value); err != nil {
+			errs.add(f.field, "%v", err)
+		}
+	}
+	if err := errs.err(path); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// flagGiven reports whether name was set on the command line.
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
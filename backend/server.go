@@ -1,22 +1,142 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
-	loadGraph("graph/graph.json")
+	if len(os.Args) > 1 {
+		commands := map[string]func([]string) error{
+			"sim":           runSimCommand,
+			"replay":        runReplayCommand,
+			"verify":        runVerifyCommand,
+			"import-osm":    runImportOSMCommand,
+			"convert-graph": runConvertGraphCommand,
+			"build-ch":      runBuildCHCommand,
+			"check-ch":      runCheckCHCommand,
+		}
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	seed := flag.Int64("seed", time.Now().UnixNano(), "random seed; reuse one to replay a run")
+	speed := flag.Float64("speed", 1, "simulation speed: 1 is real time, 10 is ten times faster, 0 runs as fast as possible")
+	dispatcherName := flag.String("dispatcher", "greedy", "dispatch strategy: greedy or hungarian")
+	capacity := flag.Int("capacity", 1, "riders each driver carries at once; above 1 enables pooling")
+	maxDetour := flag.Duration("max-detour", defaultMaxDetour, "most a pooled pickup may delay riders already on a route")
+	patience := flag.Duration("patience", defaultPatience, "how long a customer waits for a match before giving up; 0 waits forever")
+	demandPath := flag.String("demand", "", "JSON demand profile; customers then arrive on their own as well as on request")
+	graphPath := flag.String("graph", "graph/graph.json", "road graph to load, JSON or binary")
+	scenarioPath := flag.String("scenario", "", "JSON scenario file; flags given on the command line override it")
+	tripsPath := flag.String("trips", "trips.db", "BoltDB file for trip history; empty keeps trips in memory")
+	snapshotPath := flag.String("snapshot", "snapshot.json", "file to save simulation state to and restore it from; empty disables snapshots")
+	snapshotEvery := flag.Duration("snapshot-every", time.Minute, "how often to save a snapshot; 0 only saves on shutdown or request")
+	eventLogPath := flag.String("event-log", "", "JSON-lines file to append the default simulation's events to, for `ridesync replay`")
+	flag.Parse()
+
+	var sc *Scenario
+	if *scenarioPath != "" {
+		var err error
+		if sc, err = applyScenario(flag.CommandLine, *scenarioPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Simulations created through /sims start from the same settings
+	simDefaults = SimConfig{Speed: *speed, Dispatcher: *dispatcherName, Capacity: *capacity, MaxDetour: *maxDetour, Patience: *patience}
+	if *demandPath != "" {
+		demand, err := loadDemandProfile(*demandPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		simDefaults.Demand = demand
+	} else if sc != nil {
+		simDefaults.Demand = sc.Demand
+	}
+	if sc != nil && len(sc.Fleet) > 0 {
+		simDefaults.Fleet = sc.driverSpecs(*capacity)
+	}
+	cfg := simDefaults
+	cfg.Seed = *seed
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+
+	store, err := openTripStore(*tripsPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	loadGraph(*graphPath)
+	if sc != nil {
+		if err := sc.checkGraph(roadGraph, *scenarioPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The original routes all drive one default simulation; /sims/{id}/...
+	// reaches the others
+	sim, err := NewSimulation(defaultSimID, roadGraph, cfg, store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := simulations.Add(sim); err != nil {
+		log.Fatal(err)
+	}
+
+	var eventLog *EventLog
+	if *eventLogPath != "" {
+		run := SimRun{Graph: *graphPath, Config: cfg, Start: sim.Clock.Now()}
+		if eventLog, err = createEventLog(*eventLogPath, EventLogHeader{Source: "server", Run: run}, roadGraph); err != nil {
+			log.Fatal(err)
+		}
+		sim.Events.SetRecorder(eventLog)
+		log.Printf("Recording events to %s\n", *eventLogPath)
+	}
+
+	snapshotFile = *snapshotPath
+	if snapshotFile != "" {
+		snap, err := loadSnapshot(snapshotFile)
+		if err != nil {
+			log.Printf("⚠️ Ignoring snapshot: %v\n", err)
+		} else if snap != nil {
+			sim.restoreSnapshot(snap)
+			log.Printf("Restored snapshot from %s saved %s (%d drivers, %d waiting)\n",
+				snapshotFile, snap.SavedAt.Format(time.RFC3339), len(snap.Drivers), len(snap.Queue))
+		}
+		if *snapshotEvery > 0 {
+			go sim.saveSnapshotsEvery(snapshotFile, *snapshotEvery)
+		}
+	}
+	sim.shutdownOnSignal(snapshotFile, eventLog)
+	log.Printf("Simulation seed %d\n", sim.Seed)
+
 	fs := http.FileServer(http.Dir("frontend/"))
 	http.Handle("/", fs)
-	http.HandleFunc("/set-grid", setGrid)
-	http.HandleFunc("/get-heatmap-data", handleHeatmapData)
-	http.HandleFunc("/get-customer", getCustomer)
-	http.HandleFunc("/get-pairing", getPairing)
-	http.HandleFunc("/get-cust-que", getCustQ)
-	http.HandleFunc("/get-drivers", getDrivers)
-	http.HandleFunc("/assign-customer", assignCustomer)
+	http.HandleFunc("/set-grid", withSim(defaultSimID, setGrid))
+	http.HandleFunc("/get-heatmap-data", withSim(defaultSimID, handleHeatmapData))
+	http.HandleFunc("/get-customer", withSim(defaultSimID, getCustomer))
+	http.HandleFunc("/get-pairing", withSim(defaultSimID, getPairing))
+	http.HandleFunc("/get-cust-que", withSim(defaultSimID, getCustQ))
+	http.HandleFunc("/get-drivers", withSim(defaultSimID, getDrivers))
+	http.HandleFunc("/cancel-customer", withSim(defaultSimID, handleCancelCustomer))
 	http.HandleFunc("/get-graph-path", getGraphPath)
+	http.HandleFunc("/matrix", handleMatrix)
+	http.HandleFunc("/events", withSim(defaultSimID, streamEvents))
+	http.HandleFunc("/trips", withSim(defaultSimID, getTrips))
+	http.HandleFunc("/admin/snapshot", withSim(defaultSimID, adminSnapshot))
+	http.HandleFunc("/sims", handleSims)
+	http.HandleFunc("/sims/", routeSim)
 
 	fmt.Println("Server running at :8080")
 
@@ -7,7 +7,7 @@ import (
 	"net/http"
 )
 
-func setGrid(w http.ResponseWriter, r *http.Request) {
+func setGrid(sim *Simulation, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -22,48 +22,13 @@ func setGrid(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !driversInitialized {
-		driverList = []Driver{}
-		names := []string{"Foe", "Joe", "Poe", "Doe", "Bow", "Crow", "Low", "Bro", "Flow", "Row", "Glo", "Oh"}
-		nodeKeys := make([]string, 0, len(graph))
-		for k := range graph {
-			nodeKeys = append(nodeKeys, k)
-		}
-
-		if len(nodeKeys) == 0 {
-			log.Println("❌ Cannot assign start/end keys: graph is empty.")
-			http.Error(w, "Graph data is empty. Cannot assign drivers.", http.StatusInternalServerError)
-			return
-		}
-
-		for _, name := range names {
-			startKey := nodeKeys[rand.Intn(len(nodeKeys))]
-			endKey := nodeKeys[rand.Intn(len(nodeKeys))]
-
-			start := graph[startKey]
-			end := graph[endKey]
-			path := aStarGraphCoords(start.Lat, start.Lon, end.Lat, end.Lon)
-			driver := Driver{
-				Name:         name,
-				Lat:          start.Lat,
-				Lon:          start.Lon,
-				DestLat:      end.Lat,
-				DestLon:      end.Lon,
-				Dir:          "down",
-				OnPickupLeg:  false,
-				GraphPath:    path,
-				PathIndex:    0,
-				ResourceLeft: 40.0,
-				CurrentSpeed: 30.0,
-				ETA:          estimateETA(path),
-			}
-			driverList = append(driverList, driver)
-
-		}
-		driversInitialized = true
+	if sim.Graph.NumNodes() == 0 {
+		log.Println("❌ Cannot assign start/end keys: graph is empty.")
+		http.Error(w, "Graph data is empty. Cannot assign drivers.", http.StatusInternalServerError)
+		return
+	}
+	if sim.Start() {
 		fmt.Println("Drivers initialized")
-		fmt.Println("moveDrivers started, driver count:", len(driverList))
-		go moveDrivers()
 	} else {
 		fmt.Println("Drivers already initialized — skipping re-init")
 	}
@@ -71,3 +36,72 @@ func setGrid(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Println("Sim Initialized")
 }
+
+var driverNames = []string{"Foe", "Joe", "Poe", "Doe", "Bow", "Crow", "Low", "Bro", "Flow", "Row", "Glo", "Oh"}
+
+// DriverSpec is one driver a simulation starts with.
+type DriverSpec struct {
+	Name    string `json:"name"`
+	Vehicle string `json:"vehicle"` // the vehicle type's name
+	VehicleType
+	StartNode string `json:"startNode"` // graph key; empty starts the driver at a random node
+}
+
+// VehicleType is what a driver drives.
+type VehicleType struct {
+	Capacity     int     `json:"capacity"`     // riders at once
+	FuelCapacity float64 `json:"fuelCapacity"` // liters
+	FuelPerKm    float64 `json:"fuelPerKm"`    // liters
+}
+
+// defaultVehicle, named defaultVehicleName, is what drivers drive unless a
+// scenario says otherwise.
+const defaultVehicleName = "standard"
+
+var defaultVehicle = VehicleType{Capacity: 1, FuelCapacity: 40, FuelPerKm: fuelPerMeter * 1000}
+
+// uniformFleet is one default vehicle per name, each seating capacity.
+func uniformFleet(names []string, capacity int) []DriverSpec {
+	fleet := make([]DriverSpec, len(names))
+	for i, name := range names {
+		fleet[i] = DriverSpec{Name: name, Vehicle: defaultVehicleName, VehicleType: defaultVehicle}
+		fleet[i].Capacity = capacity
+	}
+	return fleet
+}
+
+// spawnDrivers places each driver at its start node, or a random spawnable
+// one, already heading to another.
+func spawnDrivers(g *RoadGraph, fleet []DriverSpec, rng *rand.Rand) []Driver {
+	drivers := []Driver{}
+	for _, spec := range fleet {
+		var start GraphNode
+		if node, ok := g.Lookup(spec.StartNode); ok {
+			start = g.Node(node)
+		} else {
+			start = getRandomNode(g, rng)
+		}
+		end := getRandomNode(g, rng)
+		path := aStarGraphCoords(g, start.Lat, start.Lon, end.Lat, end.Lon)
+		driver := Driver{
+			Name:         spec.Name,
+			Lat:          start.Lat,
+			Lon:          start.Lon,
+			DestLat:      end.Lat,
+			DestLon:      end.Lon,
+			Dir:          "down",
+			OnPickupLeg:  false,
+			GraphPath:    path,
+			PathIndex:    0,
+			ResourceLeft: spec.FuelCapacity,
+			CurrentSpeed: 30.0,
+			ETA:          estimateETA(g, path),
+			Capacity:     spec.Capacity,
+			Vehicle:      spec.Vehicle,
+			FuelCapacity: spec.FuelCapacity,
+			FuelPerKm:    spec.FuelPerKm,
+		}
+		drivers = append(drivers, driver)
+	}
+	return drivers
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runSimCommand implements `ridesync sim`: a headless run on an event clock
+// that writes a metrics summary instead of serving HTTP.
+func runSimCommand(args []string) error {
+	fs := flag.NewFlagSet("sim", flag.ExitOnError)
+	graphPath := fs.String("graph", "graph/graph.json", "road graph to load, JSON or binary")
+	drivers := fs.Int("drivers", len(driverNames), "number of drivers")
+	rate := fs.Float64("rate", 30, "mean customer arrivals per simulated hour (Poisson), when there is no -demand")
+	demandPath := fs.String("demand", "", "JSON demand profile with zones and hourly arrival rates; overrides -rate")
+	startHour := fs.Int("start-hour", 0, "simulated hour of the day (0-23) the run starts at")
+	duration := fs.Duration("duration", 2*time.Hour, "simulated time to run")
+	seed := fs.Int64("seed", 1, "random seed")
+	dispatcherName := fs.String("dispatcher", "greedy", "dispatch strategy: greedy or hungarian")
+	capacity := fs.Int("capacity", 1, "riders each driver carries at once; above 1 enables pooling")
+	maxDetour := fs.Duration("max-detour", defaultMaxDetour, "most a pooled pickup may delay riders already on a route")
+	patience := fs.Duration("patience", defaultPatience, "how long a customer waits for a match before giving up; 0 waits forever")
+	tripsPath := fs.String("trips", "", "BoltDB file to record trips in; empty keeps them in memory")
+	out := fs.String("out", "sim-summary.json", "summary file; a .csv extension writes CSV, anything else JSON")
+	scenarioPath := fs.String("scenario", "", "JSON scenario file; flags given on the command line override it")
+	eventLogPath := fs.String("event-log", "", "JSON-lines file to append every event to, for `ridesync replay` and `ridesync verify`")
+	fs.Parse(args)
+
+	var sc *Scenario
+	if *scenarioPath != "" {
+		var err error
+		if sc, err = applyScenario(fs, *scenarioPath); err != nil {
+			return err
+		}
+	}
+
+	if *drivers <= 0 {
+		return fmt.Errorf("-drivers must be positive, got %d", *drivers)
+	}
+	if *rate < 0 {
+		return fmt.Errorf("-rate must not be negative, got %v", *rate)
+	}
+	if *startHour < 0 || *startHour > 23 {
+		return fmt.Errorf("-start-hour must be between 0 and 23, got %d", *startHour)
+	}
+	demand := uniformDemand(*rate)
+	if *demandPath != "" {
+		var err error
+		if demand, err = loadDemandProfile(*demandPath); err != nil {
+			return err
+		}
+	} else if sc != nil && sc.Demand != nil && !flagGiven(fs, "rate") {
+		demand = sc.Demand
+	}
+	cfg := SimConfig{
+		Seed:       *seed,
+		Speed:      0,
+		Dispatcher: *dispatcherName,
+		Capacity:   *capacity,
+		MaxDetour:  *maxDetour,
+		Drivers:    *drivers,
+		Patience:   *patience,
+		Demand:     demand,
+	}
+	if sc != nil && len(sc.Fleet) > 0 && !flagGiven(fs, "drivers") {
+		cfg.Fleet = sc.driverSpecs(*capacity)
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	store, err := openTripStore(*tripsPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	loadGraph(*graphPath)
+	if roadGraph.NumNodes() == 0 {
+		return fmt.Errorf("graph %s is empty", *graphPath)
+	}
+	if sc != nil {
+		if err := sc.checkGraph(roadGraph, *scenarioPath); err != nil {
+			return err
+		}
+	}
+
+	run := SimRun{
+		Graph:    *graphPath,
+		Config:   cfg,
+		Start:    time.Date(2024, time.January, 1, *startHour, 0, 0, 0, time.UTC),
+		Duration: *duration,
+	}
+	var recorder EventRecorder
+	if *eventLogPath != "" {
+		eventLog, err := createEventLog(*eventLogPath, EventLogHeader{Source: "sim", Run: run}, roadGraph)
+		if err != nil {
+			return err
+		}
+		defer eventLog.Close()
+		recorder = eventLog
+	}
+
+	summary, err := run.execute(roadGraph, store, recorder)
+	if err != nil {
+		return err
+	}
+	return writeSummary(*out, summary)
+}
+
+// SimRun is everything that decides how a headless run goes, so an event
+// log can carry it and `ridesync verify` can run it again.
+type SimRun struct {
+	Graph    string        `json:"graph"`
+	Config   SimConfig     `json:"config"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+// execute runs the simulation on g from Start for Duration on an event
+// clock. recorder, if not nil, sees every event, starting with a snapshot
+// of the spawned fleet.
+func (run SimRun) execute(g *RoadGraph, store TripStore, recorder EventRecorder) (SimSummary, error) {
+	sim, err := NewSimulation("sim", g, run.Config, store)
+	if err != nil {
+		return SimSummary{}, err
+	}
+	clock := NewEventClock(run.Start)
+	sim.Clock = clock
+	sim.Events.SetRecorder(recorder)
+	sim.Drivers = spawnDrivers(sim.Graph, sim.fleet(), sim.Rand)
+	sim.recordSnapshot()
+
+	end := run.Start.Add(run.Duration)
+	requested := 0
+
+	wake := run.Start
+	for {
+		now := clock.SleepUntil(wake)
+		if !now.Before(end) {
+			break
+		}
+		var arrived int
+		arrived, wake = sim.stepEvents(now)
+		requested += arrived
+	}
+
+	summary := sim.Stats.Summary()
+	summary.Seed = sim.Seed
+	summary.Dispatcher = sim.Dispatcher.Name()
+	summary.Drivers = len(sim.Drivers)
+	summary.Capacity = run.Config.Capacity
+	summary.DurationMinutes = run.Duration.Minutes()
+	summary.CustomersRequested = requested
+	summary.CustomersWaiting = len(sim.Queue)
+	return summary, nil
+}
+
+// fleetNames reuses the server's driver names, numbering repeats once the
+// fleet outgrows the list.
+func fleetNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = driverNames[i%len(driverNames)]
+		if i >= len(driverNames) {
+			names[i] += strconv.Itoa(i/len(driverNames) + 1)
+		}
+	}
+	return names
+}
+
+func writeSummary(path string, summary SimSummary) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating summary: %w", err)
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(strings.ToLower(path), ".csv") {
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	}
+
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	w := csv.NewWriter(file)
+	w.Write([]string{"seed", "dispatcher", "drivers", "capacity", "durationMinutes", "customersRequested", "customersWaiting",
+		"customersExpired", "tripsServed", "meanWaitMinutes", "meanEtaErrorMinutes", "fuelUsedLiters", "idleMinutes"})
+	w.Write([]string{
+		strconv.FormatInt(summary.Seed, 10),
+		summary.Dispatcher,
+		strconv.Itoa(summary.Drivers),
+		strconv.Itoa(summary.Capacity),
+		f(summary.DurationMinutes),
+		strconv.Itoa(summary.CustomersRequested),
+		strconv.Itoa(summary.CustomersWaiting),
+		strconv.Itoa(summary.CustomersExpired),
+		strconv.Itoa(summary.TripsServed),
+		f(summary.MeanWaitMinutes),
+		f(summary.MeanETAErrorMinutes),
+		f(summary.FuelUsedLiters),
+		f(summary.IdleMinutes),
+	})
+	w.Flush()
+	return w.Error()
+}
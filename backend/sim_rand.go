@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// lockedSource lets HTTP handlers and moveDrivers share one seeded stream.
+// It counts its draws so a snapshot can record where the stream was.
+type lockedSource struct {
+	mu    sync.Mutex
+	src   rand.Source64
+	draws uint64
+}
+
+func newLockedSource(seed int64) *lockedSource {
+	return &lockedSource{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+func (s *lockedSource) Draws() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draws
+}
+
+// skip advances the stream past n values, as if they had been drawn.
+func (s *lockedSource) skip(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := uint64(0); i < n; i++ {
+		s.src.Uint64() // the generator steps once per value either way
+	}
+	s.draws += n
+}
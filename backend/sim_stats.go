@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// SimStats accumulates fleet metrics as stepDrivers runs.
+type SimStats struct {
+	TripsServed      int
+	CustomersExpired int
+	FuelUsed         float64 // liters
+	IdleTime         time.Duration
+
+	waitTotal   time.Duration
+	waits       int
+	etaErrTotal float64 // minutes, absolute
+	etaLegs     int
+	lastStep    time.Time
+}
+
+// SimSummary is the report written at the end of a batch run.
+type SimSummary struct {
+	Seed                int64   `json:"seed"`
+	Dispatcher          string  `json:"dispatcher"`
+	Drivers             int     `json:"drivers"`
+	Capacity            int     `json:"capacity"`
+	DurationMinutes     float64 `json:"durationMinutes"`
+	CustomersRequested  int     `json:"customersRequested"`
+	CustomersWaiting    int     `json:"customersWaiting"`
+	CustomersExpired    int     `json:"customersExpired"`
+	TripsServed         int     `json:"tripsServed"`
+	MeanWaitMinutes     float64 `json:"meanWaitMinutes"`
+	MeanETAErrorMinutes float64 `json:"meanEtaErrorMinutes"`
+	FuelUsedLiters      float64 `json:"fuelUsedLiters"`
+	IdleMinutes         float64 `json:"idleMinutes"` // summed over all drivers
+}
+
+// observe charges the time since the last step as idle time for every
+// driver without a customer.
+func (s *SimStats) observe(now time.Time, drivers []Driver) {
+	if !s.lastStep.IsZero() && now.After(s.lastStep) {
+		for i := range drivers {
+			if !drivers[i].HasCustomer {
+				s.IdleTime += now.Sub(s.lastStep)
+			}
+		}
+	}
+	s.lastStep = now
+}
+
+// legFinished compares how long the driver's current leg actually took with
+// the ETA it was given when the leg began.
+func (s *SimStats) legFinished(driver *Driver, now time.Time) {
+	if driver.LegStartedAt.IsZero() {
+		return
+	}
+	actual := now.Sub(driver.LegStartedAt).Minutes()
+	s.etaErrTotal += math.Abs(actual - driver.ETA)
+	s.etaLegs++
+}
+
+func (s *SimStats) pickedUp(driver *Driver, customer Customer, now time.Time) {
+	s.legFinished(driver, now)
+	if !customer.RequestedAt.IsZero() {
+		s.waitTotal += now.Sub(customer.RequestedAt)
+		s.waits++
+	}
+}
+
+func (s *SimStats) droppedOff(driver *Driver, now time.Time) {
+	s.legFinished(driver, now)
+	s.TripsServed++
+}
+
+func (s *SimStats) Summary() SimSummary {
+	summary := SimSummary{
+		TripsServed:      s.TripsServed,
+		CustomersExpired: s.CustomersExpired,
+		FuelUsedLiters:   s.FuelUsed,
+		IdleMinutes:      s.IdleTime.Minutes(),
+	}
+	if s.waits > 0 {
+		summary.MeanWaitMinutes = (s.waitTotal / time.Duration(s.waits)).Minutes()
+	}
+	if s.etaLegs > 0 {
+		summary.MeanETAErrorMinutes = s.etaErrTotal / float64(s.etaLegs)
+	}
+	return summary
+}
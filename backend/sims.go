@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// simHandler is an HTTP handler for one simulation.
+type simHandler func(sim *Simulation, w http.ResponseWriter, r *http.Request)
+
+// simRoutes are the endpoints each simulation serves under /sims/{id}/.
+var simRoutes = map[string]simHandler{
+	"start":    setGrid,
+	"drivers":  getDrivers,
+	"customer": getCustomer,
+	"queue":    getCustQ,
+	"pairing":  getPairing,
+	"heatmap":  handleHeatmapData,
+	"events":   streamEvents,
+	"trips":    getTrips,
+	"cancel":   handleCancelCustomer,
+}
+
+// simDefaults fills in whatever a POST /sims body leaves out.
+var simDefaults = SimConfig{Speed: 1, Dispatcher: "greedy", Capacity: 1, MaxDetour: defaultMaxDetour, Patience: defaultPatience}
+
+// withSim serves h against the simulation with the given ID, for the
+// original routes that predate /sims.
+func withSim(id string, h simHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sim, ok := simulations.Get(id)
+		if !ok {
+			http.Error(w, "Simulation not found", http.StatusNotFound)
+			return
+		}
+		h(sim, w, r)
+	}
+}
+
+// SimInfo describes a simulation for GET /sims.
+type SimInfo struct {
+	ID         string    `json:"id"`
+	Seed       int64     `json:"seed"`
+	Speed      float64   `json:"speed"`
+	Dispatcher string    `json:"dispatcher"`
+	Capacity   int       `json:"capacity"`
+	MaxDetour  string    `json:"maxDetour"`
+	Patience   string    `json:"patience"`
+	Running    bool      `json:"running"`
+	Drivers    int       `json:"drivers"`
+	Waiting    int       `json:"waiting"`
+	SimTime    time.Time `json:"simTime"`
+}
+
+func (s *Simulation) Info() SimInfo {
+	return SimInfo{
+		ID:         s.ID,
+		Seed:       s.Seed,
+		Speed:      s.Config.Speed,
+		Dispatcher: s.Dispatcher.Name(),
+		Capacity:   s.Config.Capacity,
+		MaxDetour:  s.Config.MaxDetour.String(),
+		Patience:   s.Config.Patience.String(),
+		Running:    s.Running(),
+		Drivers:    len(s.driverSnapshot()),
+		Waiting:    len(s.queueSnapshot()),
+		SimTime:    s.Clock.Now(),
+	}
+}
+
+// NewSimRequest is the optional body of POST /sims.
+type NewSimRequest struct {
+	Seed       *int64         `json:"seed"`
+	Speed      *float64       `json:"speed"`
+	Dispatcher string         `json:"dispatcher"`
+	Capacity   int            `json:"capacity"`
+	MaxDetour  string         `json:"maxDetour"` // e.g. "5m"
+	Patience   string         `json:"patience"`  // e.g. "10m"; "0s" never expires
+	Demand     *DemandProfile `json:"demand"`
+	Drivers    int            `json:"drivers"`
+}
+
+func (req NewSimRequest) config() (SimConfig, error) {
+	cfg := simDefaults
+	cfg.Seed = time.Now().UnixNano()
+	if req.Seed != nil {
+		cfg.Seed = *req.Seed
+	}
+	if req.Speed != nil {
+		cfg.Speed = *req.Speed
+	}
+	if req.Dispatcher != "" {
+		cfg.Dispatcher = req.Dispatcher
+	}
+	if req.Capacity != 0 {
+		cfg.Capacity = req.Capacity
+	}
+	if req.MaxDetour != "" {
+		d, err := time.ParseDuration(req.MaxDetour)
+		if err != nil {
+			return cfg, errors.New("invalid maxDetour: " + err.Error())
+		}
+		cfg.MaxDetour = d
+	}
+	if req.Patience != "" {
+		d, err := time.ParseDuration(req.Patience)
+		if err != nil {
+			return cfg, errors.New("invalid patience: " + err.Error())
+		}
+		cfg.Patience = d
+	}
+	if req.Demand != nil {
+		cfg.Demand = req.Demand
+	}
+	if req.Drivers != 0 || req.Capacity != 0 {
+		cfg.Fleet = nil // replaces the scenario's fleet
+	}
+	cfg.Drivers = req.Drivers
+	return cfg, nil
+}
+
+// handleSims lists simulations (GET) or creates one (POST).
+func handleSims(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	switch r.Method {
+	case http.MethodGet:
+		infos := []SimInfo{}
+		for _, sim := range simulations.List() {
+			infos = append(infos, sim.Info())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+
+	case http.MethodPost:
+		var req NewSimRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request data", http.StatusBadRequest)
+			return
+		}
+		cfg, err := req.config()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sim, err := NewSimulation(newSimID(), roadGraph, cfg, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := simulations.Add(sim); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("Created simulation %s (seed %d)\n", sim.ID, sim.Seed)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sim.Info())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// routeSim serves /sims/{id} (GET to describe, DELETE to stop) and
+// /sims/{id}/{endpoint} for each of simRoutes.
+func routeSim(w http.ResponseWriter, r *http.Request) {
+	id, endpoint, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/sims/"), "/")
+	sim, ok := simulations.Get(id)
+	if !ok {
+		http.Error(w, "Simulation not found", http.StatusNotFound)
+		return
+	}
+
+	if endpoint == "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(sim.Info())
+		case http.MethodDelete:
+			if id == defaultSimID {
+				http.Error(w, "The default simulation can't be deleted", http.StatusForbidden)
+				return
+			}
+			simulations.Remove(id)
+			log.Printf("Stopped simulation %s\n", id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	h, ok := simRoutes[endpoint]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h(sim, w, r)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SimConfig is what a simulation is created with. Event logs record it in
+// their header, durations in nanoseconds.
+type SimConfig struct {
+	Seed       int64          `json:"seed"`
+	Speed      float64        `json:"speed"` // 1 is real time, 0 runs event to event
+	Dispatcher string         `json:"dispatcher"`
+	Capacity   int            `json:"capacity"`  // riders per driver
+	MaxDetour  time.Duration  `json:"maxDetour"` // pooling delay budget
+	Drivers    int            `json:"drivers"`   // fleet size; 0 uses one driver per name in driverNames
+	Patience   time.Duration  `json:"patience"`  // how long customers wait for a match; 0 is forever
+	Demand     *DemandProfile `json:"demand"`    // where and when customers appear; nil is uniform, on request only
+	Fleet      []DriverSpec   `json:"fleet"`     // overrides Drivers and Capacity when set
+}
+
+func (cfg SimConfig) validate() error {
+	if cfg.Speed < 0 {
+		return fmt.Errorf("invalid speed %v: must be 0 or positive", cfg.Speed)
+	}
+	if cfg.Capacity < 1 {
+		return fmt.Errorf("invalid capacity %d: must be at least 1", cfg.Capacity)
+	}
+	if cfg.Drivers < 0 {
+		return fmt.Errorf("invalid driver count %d", cfg.Drivers)
+	}
+	seen := map[string]bool{}
+	for i, spec := range cfg.Fleet {
+		if spec.Name == "" || seen[spec.Name] {
+			return fmt.Errorf("invalid fleet: driver %d needs a unique name, got %q", i, spec.Name)
+		}
+		seen[spec.Name] = true
+		if spec.Capacity < 1 {
+			return fmt.Errorf("invalid fleet: driver %s has capacity %d, must be at least 1", spec.Name, spec.Capacity)
+		}
+	}
+	if cfg.Patience < 0 {
+		return fmt.Errorf("invalid patience %v: must be 0 or positive", cfg.Patience)
+	}
+	if cfg.Demand != nil {
+		if err := cfg.Demand.validate(); err != nil {
+			return fmt.Errorf("demand: %w", err)
+		}
+	}
+	_, err := newDispatcher(cfg.Dispatcher)
+	return err
+}
+
+// Simulation is one independent fleet: its drivers, waiting customers and
+// everything that moves them. The road graph is shared and read-only.
+type Simulation struct {
+	ID     string
+	Config SimConfig
+	Graph  *RoadGraph
+
+	// driverMu guards the fields below it down to started. When both locks
+	// are needed take driverMu first, then queueMu.
+	driverMu    sync.Mutex
+	Drivers     []Driver
+	Heatmap     map[string]int
+	Stats       *SimStats
+	openTrips   tripTracker
+	nextArrival time.Time // of the next customer Demand generates
+	started     bool
+
+	queueMu        sync.Mutex
+	Queue          []Customer
+	nextCustomerID int
+
+	Seed       int64
+	source     *lockedSource
+	Rand       *mathrand.Rand
+	Clock      Clock
+	Dispatcher Dispatcher
+	Demand     *Demand
+	Events     *EventHub
+	Trips      TripStore
+
+	wake     chan struct{} // nudges runDispatcher
+	done     chan struct{} // closed by Stop
+	stopOnce sync.Once
+}
+
+// NewSimulation validates cfg and returns a simulation that has not spawned
+// its drivers yet.
+func NewSimulation(id string, g *RoadGraph, cfg SimConfig, trips TripStore) (*Simulation, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	dispatcher, err := newDispatcher(cfg.Dispatcher)
+	if err != nil {
+		return nil, err
+	}
+	demand, err := newDemand(cfg.Demand, g)
+	if err != nil {
+		return nil, fmt.Errorf("demand: %w", err)
+	}
+	if trips == nil {
+		trips = NewMemoryTripStore()
+	}
+
+	s := &Simulation{
+		ID:         id,
+		Config:     cfg,
+		Graph:      g,
+		Drivers:    []Driver{},
+		Heatmap:    map[string]int{},
+		Stats:      &SimStats{},
+		openTrips:  tripTracker{},
+		Clock:      newClock(cfg.Speed),
+		Dispatcher: dispatcher,
+		Demand:     demand,
+		Events:     NewEventHub(),
+		Trips:      trips,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	s.reseed(cfg.Seed, 0)
+	return s, nil
+}
+
+// reseed replaces the random stream with a new one for seed, fast-forwarded
+// past draws values. Every random choice the simulation makes must come
+// from s.Rand, so the same seed replays the same sequence of drivers,
+// customers and delays.
+func (s *Simulation) reseed(seed int64, draws uint64) {
+	s.Seed = seed
+	s.source = newLockedSource(seed)
+	s.source.skip(draws)
+	s.Rand = mathrand.New(s.source)
+}
+
+// Start spawns the fleet and starts the driver and dispatch loops. It does
+// nothing if the simulation is already running.
+func (s *Simulation) Start() bool {
+	s.driverMu.Lock()
+	if s.started {
+		s.driverMu.Unlock()
+		return false
+	}
+	s.Drivers = spawnDrivers(s.Graph, s.fleet(), s.Rand)
+	s.driverMu.Unlock()
+	s.startLoops()
+	return true
+}
+
+// fleet is the drivers the simulation starts with: Config.Fleet, or else
+// Config.Drivers default vehicles (one per driverNames entry if that's 0).
+func (s *Simulation) fleet() []DriverSpec {
+	if len(s.Config.Fleet) > 0 {
+		return s.Config.Fleet
+	}
+	names := driverNames
+	if s.Config.Drivers > 0 {
+		names = fleetNames(s.Config.Drivers)
+	}
+	return uniformFleet(names, s.Config.Capacity)
+}
+
+// startLoops runs moveDrivers and runDispatcher for the current fleet, or
+// runEvents on an event clock.
+func (s *Simulation) startLoops() {
+	s.driverMu.Lock()
+	s.started = true
+	count := len(s.Drivers)
+	s.driverMu.Unlock()
+	s.recordSnapshot()
+	fmt.Printf("Simulation %s started, driver count: %d\n", s.ID, count)
+	if s.Clock.EventDriven() {
+		go s.runEvents()
+		return
+	}
+	go s.moveDrivers()
+	go s.runDispatcher()
+}
+
+// Running reports whether Start has been called.
+func (s *Simulation) Running() bool {
+	s.driverMu.Lock()
+	defer s.driverMu.Unlock()
+	return s.started
+}
+
+// Stop ends the simulation's loops and disconnects its event subscribers.
+func (s *Simulation) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.Events.Close()
+	})
+}
+
+func (s *Simulation) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueue gives customer the simulation's next ID and puts them in the queue
+// as requested.
+func (s *Simulation) enqueue(customer Customer) Customer {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	s.nextCustomerID++
+	customer.Id = s.nextCustomerID
+	customer.Status = CustomerRequested
+	s.Queue = append(s.Queue, customer)
+	s.Events.Publish(LiveEvent{Type: EventCustomerEnqueued, Time: customer.RequestedAt, Data: customer})
+	s.notifyDispatcher()
+	return customer
+}
+
+// queueSnapshot copies the queue so handlers can encode it without racing
+// the dispatcher.
+func (s *Simulation) queueSnapshot() []Customer {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	return append([]Customer(nil), s.Queue...)
+}
+
+// driverSnapshot copies the fleet for the same reason.
+func (s *Simulation) driverSnapshot() []Driver {
+	s.driverMu.Lock()
+	defer s.driverMu.Unlock()
+	return append([]Driver(nil), s.Drivers...)
+}
+
+func (s *Simulation) removeFromQueue(customerID int) {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	for i, c := range s.Queue {
+		if c.Id == customerID {
+			s.Queue = append(s.Queue[:i], s.Queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// addHeat counts a path towards the heatmap. Callers must hold driverMu.
+func (s *Simulation) addHeat(path []GraphNode) {
+	for _, node := range path {
+		key := fmt.Sprintf("%.5f,%.5f", node.Lat, node.Lon) // Round to reduce duplicates
+		s.Heatmap[key]++
+	}
+}
+
+// maxSimulations caps how many simulations one server will run at once.
+const maxSimulations = 32
+
+// defaultSimID is the simulation the original, un-prefixed routes use.
+const defaultSimID = "default"
+
+// SimRegistry holds the simulations a server is running, by ID.
+type SimRegistry struct {
+	mu   sync.Mutex
+	sims map[string]*Simulation
+}
+
+func NewSimRegistry() *SimRegistry {
+	return &SimRegistry{sims: map[string]*Simulation{}}
+}
+
+func (r *SimRegistry) Add(sim *Simulation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.sims[sim.ID]; ok {
+		return fmt.Errorf("simulation %q already exists", sim.ID)
+	}
+	if len(r.sims) >= maxSimulations {
+		return fmt.Errorf("already running %d simulations", maxSimulations)
+	}
+	r.sims[sim.ID] = sim
+	return nil
+}
+
+func (r *SimRegistry) Get(id string) (*Simulation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sim, ok := r.sims[id]
+	return sim, ok
+}
+
+// Remove stops and forgets a simulation.
+func (r *SimRegistry) Remove(id string) bool {
+	r.mu.Lock()
+	sim, ok := r.sims[id]
+	delete(r.sims, id)
+	r.mu.Unlock()
+	if ok {
+		sim.Stop()
+	}
+	return ok
+}
+
+// List returns the simulations sorted by ID.
+func (r *SimRegistry) List() []*Simulation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]*Simulation, 0, len(r.sims))
+	for _, sim := range r.sims {
+		out = append(out, sim)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].ID < out[b].ID })
+	return out
+}
+
+// newSimID returns a random ID that is hard to guess, so one user can't
+// stumble onto another's simulation.
+func newSimID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("sim-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
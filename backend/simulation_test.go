@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2026, time.January, 5, 8, 0, 0, 0, time.UTC)
+
+// testSim is a simulation on a 6x6 grid with one driver starting at its
+// corner, on an event clock at testStart. No customers arrive on their own.
+func testSim(t *testing.T, patience time.Duration) (*Simulation, *EventClock) {
+	t.Helper()
+	driver := DriverSpec{Name: "Ada", Vehicle: defaultVehicleName, VehicleType: defaultVehicle, StartNode: "1"}
+	cfg := SimConfig{Seed: 1, Speed: 0, Dispatcher: "greedy", Capacity: 1, MaxDetour: defaultMaxDetour, Patience: patience, Fleet: []DriverSpec{driver}}
+	sim, err := NewSimulation("test", testGrid(6, 6), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewEventClock(testStart)
+	sim.Clock = clock
+	sim.Drivers = spawnDrivers(sim.Graph, sim.fleet(), sim.Rand)
+	return sim, clock
+}
+
+// runUntil steps sim until done reports true, giving up after a simulated
+// hour.
+func runUntil(t *testing.T, sim *Simulation, clock *EventClock, done func() bool) {
+	t.Helper()
+	wake := clock.Now()
+	for !done() {
+		now := clock.SleepUntil(wake)
+		if now.Sub(testStart) > time.Hour {
+			t.Fatal("gave up after a simulated hour")
+		}
+		_, wake = sim.stepEvents(now)
+	}
+}
+
+func TestEventStepsServeCustomer(t *testing.T) {
+	sim, clock := testSim(t, 0)
+	customer := gridRider(sim.Graph, 0, 20, 35)
+	customer.RequestedAt = testStart
+	sim.enqueue(customer)
+	runUntil(t, sim, clock, func() bool { return sim.Stats.TripsServed > 0 })
+
+	trips, err := sim.Trips.Query(TripQuery{})
+	if err != nil || len(trips) != 1 {
+		t.Fatalf("got trips %+v (%v), want one", trips, err)
+	}
+	trip := trips[0]
+	// Dispatch runs on the simulated clock, in the step the customer arrives
+	if !trip.AssignedAt.Equal(testStart) {
+		t.Errorf("assigned at %v, requested at %v", trip.AssignedAt, testStart)
+	}
+	if !trip.PickedUpAt.After(trip.AssignedAt) || !trip.DroppedOffAt.After(trip.PickedUpAt) {
+		t.Errorf("assigned %v, picked up %v, dropped off %v", trip.AssignedAt, trip.PickedUpAt, trip.DroppedOffAt)
+	}
+	if trip.Driver != "Ada" || len(sim.queueSnapshot()) != 0 || sim.Drivers[0].HasCustomer {
+		t.Errorf("trip by %s, %d still waiting, driver busy %v", trip.Driver, len(sim.queueSnapshot()), sim.Drivers[0].HasCustomer)
+	}
+}
+
+func TestEventStepsExpireWaiting(t *testing.T) {
+	sim, clock := testSim(t, 3*time.Minute)
+	sim.Drivers = nil
+	customer := gridRider(sim.Graph, 0, 20, 35)
+	customer.RequestedAt = testStart
+	sim.enqueue(customer)
+	runUntil(t, sim, clock, func() bool { return len(sim.queueSnapshot()) == 0 })
+
+	waited := clock.Now().Sub(testStart)
+	if sim.Stats.CustomersExpired != 1 || waited < 3*time.Minute || waited > 3*time.Minute+moveTick {
+		t.Errorf("%d expired after %v, want 1 after 3m", sim.Stats.CustomersExpired, waited)
+	}
+}
+
+func TestStartOnEventClock(t *testing.T) {
+	sim, clock := testSim(t, 0)
+	events := sim.Events.Subscribe()
+	sim.startLoops()
+	defer sim.Stop()
+
+	customer := gridRider(sim.Graph, 0, 20, 35)
+	customer.RequestedAt = clock.Now()
+	sim.enqueue(customer)
+	// runEvents dispatches as well as moving drivers, so the trip completes
+	// without runDispatcher's wall-clock ticker
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == EventDropoff {
+				return
+			}
+		case <-timeout:
+			t.Fatal("customer was never dropped off")
+		}
+	}
+}
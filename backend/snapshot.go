@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// snapshotVersion changes whenever Snapshot or anything it embeds changes
+// shape; older snapshots are ignored rather than half-restored.
+const snapshotVersion = 4
+
+// Snapshot is everything needed to carry a running simulation across a
+// restart. Its settings (speed, dispatcher, capacity, patience, demand)
+// come from the flags the server restarts with.
+type Snapshot struct {
+	Version            int            `json:"version"`
+	SavedAt            time.Time      `json:"savedAt"` // wall clock
+	SimTime            time.Time      `json:"simTime"` // simClock at the time
+	Seed               int64          `json:"seed"`
+	RandDraws          uint64         `json:"randDraws"` // values taken from the seeded stream so far
+	DriversInitialized bool           `json:"driversInitialized"`
+	Drivers            []Driver       `json:"drivers"` // mid-path, with GraphPath and PathIndex
+	Queue              []Customer     `json:"queue"`
+	NextCustomerID     int            `json:"nextCustomerId"`
+	NextArrival        time.Time      `json:"nextArrival"` // zero if demand hasn't started
+	Heatmap            map[string]int `json:"heatmap"`
+	OpenTrips          tripTracker    `json:"openTrips"`
+}
+
+// snapshotFile is where snapshots are saved and restored from; empty turns
+// them off.
+var snapshotFile string
+
+func (s *Simulation) takeSnapshot() Snapshot {
+	s.driverMu.Lock()
+	defer s.driverMu.Unlock()
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	snap := Snapshot{
+		Version:            snapshotVersion,
+		SavedAt:            time.Now(),
+		SimTime:            s.Clock.Now(),
+		Seed:               s.Seed,
+		RandDraws:          s.source.Draws(),
+		DriversInitialized: s.started,
+		Drivers:            append([]Driver(nil), s.Drivers...),
+		Queue:              append([]Customer(nil), s.Queue...),
+		NextCustomerID:     s.nextCustomerID,
+		NextArrival:        s.nextArrival,
+		Heatmap:            make(map[string]int, len(s.Heatmap)),
+		OpenTrips:          make(tripTracker, len(s.openTrips)),
+	}
+	for k, v := range s.Heatmap {
+		snap.Heatmap[k] = v
+	}
+	for driver, trips := range s.openTrips {
+		for _, trip := range trips {
+			copied := *trip
+			copied.Route = append([]LatLon(nil), trip.Route...)
+			snap.OpenTrips[driver] = append(snap.OpenTrips[driver], &copied)
+		}
+	}
+	return snap
+}
+
+// saveSnapshot writes the current state to path, replacing it atomically so
+// a crash mid-write leaves the previous snapshot intact.
+func (s *Simulation) saveSnapshot(path string) (Snapshot, error) {
+	snap := s.takeSnapshot()
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return snap, fmt.Errorf("saving snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return snap, fmt.Errorf("saving snapshot: %w", err)
+	}
+
+	if err := json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return snap, fmt.Errorf("saving snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return snap, fmt.Errorf("saving snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return snap, fmt.Errorf("saving snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return snap, fmt.Errorf("saving snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// loadSnapshot reads the snapshot at path. A missing file is not an error;
+// it returns nil.
+func loadSnapshot(path string) (*Snapshot, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var snap Snapshot
+	if err := json.NewDecoder(file).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot %s is version %d, this build reads version %d", path, snap.Version, snapshotVersion)
+	}
+	return &snap, nil
+}
+
+// restoreSnapshot replaces the simulation's state with snap, resuming its
+// clock from the snapshot's time. It must run before the simulation starts.
+func (s *Simulation) restoreSnapshot(snap *Snapshot) {
+	s.reseed(snap.Seed, snap.RandDraws)
+	s.Clock = resumeClock(s.Config.Speed, snap.SimTime)
+	s.Drivers = snap.Drivers
+	if s.Drivers == nil {
+		s.Drivers = []Driver{}
+	}
+	s.Queue = snap.Queue
+	s.nextCustomerID = snap.NextCustomerID
+	s.nextArrival = snap.NextArrival
+	s.Heatmap = snap.Heatmap
+	if s.Heatmap == nil {
+		s.Heatmap = map[string]int{}
+	}
+	s.openTrips = snap.OpenTrips
+	if s.openTrips == nil {
+		s.openTrips = tripTracker{}
+	}
+	if snap.DriversInitialized {
+		s.startLoops()
+	}
+}
+
+// saveSnapshotsEvery saves a snapshot to path on every tick of interval.
+func (s *Simulation) saveSnapshotsEvery(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := s.saveSnapshot(path); err != nil {
+			log.Printf("❌ %v\n", err)
+		}
+	}
+}
+
+// shutdownOnSignal saves a final snapshot to path, unless it is empty, and
+// closes the trip store and event log when the process is told to stop.
+func (s *Simulation) shutdownOnSignal(path string, events *EventLog) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down\n", sig)
+		code := 0
+		if path != "" {
+			log.Printf("Saving snapshot to %s\n", path)
+			if _, err := s.saveSnapshot(path); err != nil {
+				log.Printf("❌ %v\n", err)
+				code = 1
+			}
+		}
+		s.Trips.Close()
+		if events != nil {
+			if err := events.Close(); err != nil {
+				log.Printf("❌ Closing event log: %v\n", err)
+				code = 1
+			}
+		}
+		os.Exit(code)
+	}()
+}
+
+// adminSnapshot saves a snapshot of sim on demand. When RIDESYNC_ADMIN_TOKEN
+// is set the request must carry it as a bearer token.
+func adminSnapshot(sim *Simulation, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := os.Getenv("RIDESYNC_ADMIN_TOKEN"); token != "" {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, []byte("Bearer "+token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if snapshotFile == "" {
+		http.Error(w, "Snapshots are disabled", http.StatusConflict)
+		return
+	}
+
+	snap, err := sim.saveSnapshot(snapshotFile)
+	if err != nil {
+		log.Printf("❌ %v\n", err)
+		http.Error(w, "Failed to save snapshot", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":    snapshotFile,
+		"version": snap.Version,
+		"savedAt": snap.SavedAt,
+		"simTime": snap.SimTime,
+		"drivers": len(snap.Drivers),
+		"queue":   len(snap.Queue),
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// NodeIndex buckets graph nodes into a regular lat/lon grid so nearest-node
+// and radius queries only visit cells close to the query point. Only nodes
+// in the graph's main component, and edges between them, are indexed, so
+// nothing is ever snapped onto an island.
+type NodeIndex struct {
+	g       *RoadGraph
+	minLat  float64
+	minLon  float64
+	cellDeg float64
+	rows    int
+	cols    int
+	// Nodes in cell c are cellNodes[cellStart[c]:cellStart[c+1]].
+	cellStart []int32
+	cellNodes []int32
+	// Edges whose bounding box overlaps cell c are
+	// edgeCells[edgeCellStart[c]:edgeCellStart[c+1]].
+	edgeCellStart []int32
+	edgeCells     []int32
+	// Shortest side of a cell in meters, used to bound the distance to a ring.
+	cellMeters float64
+}
+
+// NearestOptions filters which nodes a query may return.
+type NearestOptions struct {
+	MinNeighbors int // skip nodes with fewer outgoing edges
+}
+
+// snapOptions is what routing uses: nodes with fewer than two neighbours are
+// usually dead ends the driver can't leave.
+var snapOptions = NearestOptions{MinNeighbors: 2}
+
+func buildNodeIndex(g *RoadGraph) *NodeIndex {
+	ix := &NodeIndex{g: g, cellDeg: 0.002} // ~200m
+	n := g.NumNodes()
+	if n == 0 {
+		ix.rows, ix.cols = 1, 1
+		ix.cellStart = make([]int32, 2)
+		ix.edgeCellStart = make([]int32, 2)
+		return ix
+	}
+
+	minLat, maxLat, minLon, maxLon := g.Lat.At(0), g.Lat.At(0), g.Lon.At(0), g.Lon.At(0)
+	for i := int32(1); i < int32(n); i++ {
+		minLat = math.Min(minLat, g.Lat.At(i))
+		maxLat = math.Max(maxLat, g.Lat.At(i))
+		minLon = math.Min(minLon, g.Lon.At(i))
+		maxLon = math.Max(maxLon, g.Lon.At(i))
+	}
+	ix.minLat, ix.minLon = minLat, minLon
+	// Grow cells for sparse graphs so the grid stays within a few cells per node
+	for {
+		ix.rows = int((maxLat-minLat)/ix.cellDeg) + 1
+		ix.cols = int((maxLon-minLon)/ix.cellDeg) + 1
+		if ix.rows*ix.cols <= 4*n {
+			break
+		}
+		ix.cellDeg *= 2
+	}
+	widest := math.Max(math.Abs(minLat), math.Abs(maxLat))
+	ix.cellMeters = haversine(0, 0, ix.cellDeg, 0) * math.Cos(widest*math.Pi/180)
+
+	cells := make([]int32, n)
+	ix.cellStart = make([]int32, ix.rows*ix.cols+1)
+	for i := 0; i < n; i++ {
+		if !g.Main[i] {
+			continue
+		}
+		r, c := ix.cellOf(g.Lat.At(int32(i)), g.Lon.At(int32(i)))
+		cells[i] = int32(r*ix.cols + c)
+		ix.cellStart[cells[i]+1]++
+	}
+	for c := 1; c < len(ix.cellStart); c++ {
+		ix.cellStart[c] += ix.cellStart[c-1]
+	}
+	ix.cellNodes = make([]int32, ix.cellStart[len(ix.cellStart)-1])
+	fill := append([]int32(nil), ix.cellStart[:len(ix.cellStart)-1]...)
+	for i := 0; i < n; i++ {
+		if !g.Main[i] {
+			continue
+		}
+		ix.cellNodes[fill[cells[i]]] = int32(i)
+		fill[cells[i]]++
+	}
+
+	// Edges go in every cell their bounding box touches, so the cell holding
+	// the closest point on an edge always lists that edge.
+	ix.edgeCellStart = make([]int32, ix.rows*ix.cols+1)
+	ix.eachEdgeCell(func(cell int, e int32) { ix.edgeCellStart[cell+1]++ })
+	for c := 1; c < len(ix.edgeCellStart); c++ {
+		ix.edgeCellStart[c] += ix.edgeCellStart[c-1]
+	}
+	ix.edgeCells = make([]int32, ix.edgeCellStart[len(ix.edgeCellStart)-1])
+	fill = append(fill[:0], ix.edgeCellStart[:len(ix.edgeCellStart)-1]...)
+	ix.eachEdgeCell(func(cell int, e int32) {
+		ix.edgeCells[fill[cell]] = e
+		fill[cell]++
+	})
+	return ix
+}
+
+func (ix *NodeIndex) eachEdgeCell(visit func(cell int, e int32)) {
+	g := ix.g
+	for from := int32(0); from < int32(g.NumNodes()); from++ {
+		if !g.Main[from] {
+			continue
+		}
+		lo, hi := g.Edges(from)
+		for e := lo; e < hi; e++ {
+			to := g.EdgeTo[e]
+			if !g.Main[to] {
+				continue
+			}
+			r0, c0 := ix.cellOf(math.Min(g.Lat.At(from), g.Lat.At(to)), math.Min(g.Lon.At(from), g.Lon.At(to)))
+			r1, c1 := ix.cellOf(math.Max(g.Lat.At(from), g.Lat.At(to)), math.Max(g.Lon.At(from), g.Lon.At(to)))
+			for r := r0; r <= r1; r++ {
+				for c := c0; c <= c1; c++ {
+					visit(r*ix.cols+c, e)
+				}
+			}
+		}
+	}
+}
+
+// cellOf returns the cell holding (lat, lon), clamped to the grid.
+func (ix *NodeIndex) cellOf(lat, lon float64) (int, int) {
+	r := int(math.Floor((lat - ix.minLat) / ix.cellDeg))
+	c := int(math.Floor((lon - ix.minLon) / ix.cellDeg))
+	return clampInt(r, 0, ix.rows-1), clampInt(c, 0, ix.cols-1)
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// maxRing is the last ring around (row, col) that still touches the grid.
+func (ix *NodeIndex) maxRing(row, col int) int {
+	r := row
+	if ix.rows-1-row > r {
+		r = ix.rows - 1 - row
+	}
+	if col > r {
+		r = col
+	}
+	if ix.cols-1-col > r {
+		r = ix.cols - 1 - col
+	}
+	return r
+}
+
+// ring calls visit for every node in the cells exactly r steps (Chebyshev)
+// away from (row, col).
+func (ix *NodeIndex) ring(row, col, r int, visit func(int32)) {
+	ix.ringCells(row, col, r, func(cell int) {
+		for _, node := range ix.cellNodes[ix.cellStart[cell]:ix.cellStart[cell+1]] {
+			visit(node)
+		}
+	})
+}
+
+func (ix *NodeIndex) ringCells(row, col, r int, visit func(cell int)) {
+	for dr := -r; dr <= r; dr++ {
+		rr := row + dr
+		if rr < 0 || rr >= ix.rows {
+			continue
+		}
+		step := 2 * r
+		if dr == -r || dr == r || r == 0 {
+			step = 1
+		}
+		for dc := -r; dc <= r; dc += step {
+			cc := col + dc
+			if cc < 0 || cc >= ix.cols {
+				continue
+			}
+			visit(rr*ix.cols + cc)
+		}
+	}
+}
+
+// ringDistance is a lower bound in meters on the distance from any point in
+// the query's cell to any node in ring r.
+func (ix *NodeIndex) ringDistance(r int) float64 {
+	if r <= 1 {
+		return 0
+	}
+	return float64(r-1) * ix.cellMeters
+}
+
+func (ix *NodeIndex) accept(node int32, opt NearestOptions) bool {
+	lo, hi := ix.g.Edges(node)
+	return int(hi-lo) >= opt.MinNeighbors
+}
+
+// Nearest returns the closest node to (lat, lon) that passes opt.
+func (ix *NodeIndex) Nearest(lat, lon float64, opt NearestOptions) (int32, bool) {
+	found := ix.KNearest(lat, lon, 1, opt)
+	if len(found) == 0 {
+		return -1, false
+	}
+	return found[0], true
+}
+
+// KNearest returns up to k nodes that pass opt, closest first.
+func (ix *NodeIndex) KNearest(lat, lon float64, k int, opt NearestOptions) []int32 {
+	if k <= 0 {
+		return nil
+	}
+	best := &candidateHeap{}
+	row, col := ix.cellOf(lat, lon)
+	last := ix.maxRing(row, col)
+	for r := 0; r <= last; r++ {
+		if best.Len() == k && ix.ringDistance(r) > (*best)[0].dist {
+			break
+		}
+		ix.ring(row, col, r, func(node int32) {
+			if !ix.accept(node, opt) {
+				return
+			}
+			d := haversine(lat, lon, ix.g.Lat.At(node), ix.g.Lon.At(node))
+			if best.Len() < k {
+				heap.Push(best, candidate{node, d})
+			} else if d < (*best)[0].dist {
+				(*best)[0] = candidate{node, d}
+				heap.Fix(best, 0)
+			}
+		})
+	}
+
+	out := make([]int32, best.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(best).(candidate).node
+	}
+	return out
+}
+
+// WithinRadius returns every node that passes opt within meters of (lat, lon),
+// closest first.
+func (ix *NodeIndex) WithinRadius(lat, lon, meters float64, opt NearestOptions) []int32 {
+	var found []candidate
+	row, col := ix.cellOf(lat, lon)
+	last := ix.maxRing(row, col)
+	for r := 0; r <= last && ix.ringDistance(r) <= meters; r++ {
+		ix.ring(row, col, r, func(node int32) {
+			if !ix.accept(node, opt) {
+				return
+			}
+			if d := haversine(lat, lon, ix.g.Lat.At(node), ix.g.Lon.At(node)); d <= meters {
+				found = append(found, candidate{node, d})
+			}
+		})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].dist < found[j].dist })
+
+	out := make([]int32, len(found))
+	for i, c := range found {
+		out[i] = c.node
+	}
+	return out
+}
+
+// NearestEdge returns the closest point on any edge whose end node passes opt,
+// so a driver placed there can always continue along the road.
+func (ix *NodeIndex) NearestEdge(lat, lon float64, opt NearestOptions) (EdgeSnap, bool) {
+	g := ix.g
+	best := EdgeSnap{Edge: -1, Dist: math.MaxFloat64}
+	row, col := ix.cellOf(lat, lon)
+	last := ix.maxRing(row, col)
+	for r := 0; r <= last && ix.ringDistance(r) <= best.Dist; r++ {
+		ix.ringCells(row, col, r, func(cell int) {
+			for _, e := range ix.edgeCells[ix.edgeCellStart[cell]:ix.edgeCellStart[cell+1]] {
+				if e == best.Edge || !ix.accept(g.EdgeTo[e], opt) {
+					continue
+				}
+				if snap := g.snapToEdge(e, lat, lon); snap.Dist < best.Dist {
+					best = snap
+				}
+			}
+		})
+	}
+	return best, best.Edge >= 0
+}
+
+type candidate struct {
+	node int32
+	dist float64
+}
+
+// candidateHeap is a max-heap on distance, so the root is the worst of the
+// current k best.
+type candidateHeap []candidate
+
+func (h candidateHeap) Len() int            { return len(h) }
+func (h candidateHeap) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h candidateHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -25,6 +25,10 @@ type GraphNode struct {
 	Neighbors    map[string]NeighborInfo `json:"neighbors"` // keyed by neighbor node ID
 	TrafficLight bool                    `json:"traffic_light"`
 	StopSign     bool                    `json:"stop_sign"`
+	Turns        []TurnRule              `json:"turns,omitempty"` // restrictions and penalties for turns through this node
+	// Only set on path points snapped part-way along an edge (ID == -1)
+	EdgeFrom int `json:"edgeFrom,omitempty"`
+	EdgeTo   int `json:"edgeTo,omitempty"`
 }
 type PathRequest struct {
 	Grid      [][]GridObject `json:"grid"`
@@ -35,12 +39,21 @@ type PathRequest struct {
 }
 
 type Customer struct {
-	Id             int     `json:"id"`
-	Name           string  `json:"name"`
-	Lat            float64 `json:"lat"`
-	Lon            float64 `json:"lon"`
-	DestinationLat float64 `json:"destinationLat"`
-	DestinationLon float64 `json:"destinationLon"`
+	Id             int       `json:"id"`
+	Name           string    `json:"name"`
+	Lat            float64   `json:"lat"`
+	Lon            float64   `json:"lon"`
+	DestinationLat float64   `json:"destinationLat"`
+	DestinationLon float64   `json:"destinationLon"`
+	RequestedAt    time.Time `json:"requestedAt"`
+	Status         string    `json:"status"` // see customer_status.go
+	// When each later status was entered; zero until it is
+	MatchedAt        time.Time `json:"matchedAt"`
+	DriverArrivingAt time.Time `json:"driverArrivingAt"`
+	PickedUpAt       time.Time `json:"pickedUpAt"`
+	CompletedAt      time.Time `json:"completedAt"`
+	CancelledAt      time.Time `json:"cancelledAt"`
+	ExpiredAt        time.Time `json:"expiredAt"`
 }
 
 type CustStuff struct {
@@ -72,6 +85,12 @@ type Driver struct {
 	MoveTime      time.Time   `json:"moveTime"`
 	AnimationTime time.Time   `json:"animationTime"`
 	ETA           float64     `json:"eta"`
+	LegStartedAt  time.Time   `json:"legStartedAt"` // when the current pickup or drop-off leg began
+	Capacity      int         `json:"capacity"`     // riders at once; above 1 the driver pools
+	Stops         []Stop      `json:"stops"`        // pickups and drop-offs still to make, in order
+	Vehicle       string      `json:"vehicle"`      // vehicle type name
+	FuelCapacity  float64     `json:"fuelCapacity"` // liters; ResourceLeft refills to this
+	FuelPerKm     float64     `json:"fuelPerKm"`    // liters
 }
 
 type CustomerRequest struct {
@@ -91,14 +110,18 @@ type PairingRequest struct {
 	Drivers []Driver `json:"drivers"`
 }
 
-type PathNode struct {
-	ID     int
-	G      float64
-	F      float64
-	Parent *PathNode
-}
-
 type NeighborInfo struct {
 	Distance float64 `json:"distance"`
 	Speed    float64 `json:"speed"` // km/h, optional
 }
+
+// TurnRule governs the turn through a node from the road arriving from
+// neighbor From onto the road leaving for neighbor To. Kind is "no" (the turn
+// is banned), "only" (no other turn is allowed from From) or "penalty" (the
+// turn takes Seconds instead of its usual delay).
+type TurnRule struct {
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Kind    string  `json:"kind"`
+	Seconds float64 `json:"seconds,omitempty"`
+}
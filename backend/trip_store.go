@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Trip is the record of one customer served, written once they are dropped
+// off.
+type Trip struct {
+	ID           uint64    `json:"id"`
+	CustomerID   int       `json:"customerId"`
+	CustomerName string    `json:"customerName"`
+	Driver       string    `json:"driver"`
+	RequestedAt  time.Time `json:"requestedAt"`
+	AssignedAt   time.Time `json:"assignedAt"`
+	PickedUpAt   time.Time `json:"pickedUpAt"`
+	DroppedOffAt time.Time `json:"droppedOffAt"`
+	Route        []LatLon  `json:"route"`          // driven with the customer on board
+	Distance     float64   `json:"distanceMeters"` // along Route
+	Fuel         float64   `json:"fuelLiters"`     // along Route; pooled riders each count the whole car
+
+	// Minutes from assignment to pickup: as promised, and as it happened
+	PredictedETA float64 `json:"predictedEtaMinutes"`
+	ActualETA    float64 `json:"actualEtaMinutes"`
+}
+
+// TripQuery selects trips that were dropped off in [From, To). Zero fields
+// don't filter.
+type TripQuery struct {
+	From   time.Time
+	To     time.Time
+	Driver string
+	Limit  int
+}
+
+func (q TripQuery) matches(t Trip) bool {
+	if !q.From.IsZero() && t.DroppedOffAt.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !t.DroppedOffAt.Before(q.To) {
+		return false
+	}
+	return q.Driver == "" || t.Driver == q.Driver
+}
+
+// TripStore keeps completed trips. Query returns them in drop-off order.
+type TripStore interface {
+	Save(trip Trip) (Trip, error) // assigns and returns the trip's ID
+	Query(q TripQuery) ([]Trip, error)
+	Close() error
+}
+
+// openTripStore returns a BoltDB store at path, or an in-memory one when
+// path is empty.
+func openTripStore(path string) (TripStore, error) {
+	if path == "" {
+		return NewMemoryTripStore(), nil
+	}
+	store, err := OpenBoltTripStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening trip store %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// MemoryTripStore keeps trips in a slice in drop-off order; it forgets them
+// on exit.
+type MemoryTripStore struct {
+	mu    sync.Mutex
+	trips []Trip
+}
+
+func NewMemoryTripStore() *MemoryTripStore {
+	return &MemoryTripStore{}
+}
+
+func (s *MemoryTripStore) Save(trip Trip) (Trip, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trip.ID = uint64(len(s.trips) + 1)
+	// After any trip dropped off at the same time, as Bolt's keys sort
+	i := sort.Search(len(s.trips), func(i int) bool { return s.trips[i].DroppedOffAt.After(trip.DroppedOffAt) })
+	s.trips = append(s.trips, Trip{})
+	copy(s.trips[i+1:], s.trips[i:])
+	s.trips[i] = trip
+	return trip, nil
+}
+
+func (s *MemoryTripStore) Query(q TripQuery) ([]Trip, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Trip{}
+	for _, t := range s.trips {
+		if !q.matches(t) {
+			continue
+		}
+		out = append(out, t)
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (s *MemoryTripStore) Close() error { return nil }
+
+// tripTracker follows trips from assignment to drop-off, keyed by driver
+// name. Callers must hold the simulation's driverMu.
+type tripTracker map[string][]*Trip
+
+func (t tripTracker) find(driver string, customer Customer) (*Trip, int) {
+	for i, trip := range t[driver] {
+		if trip.CustomerID == customer.Id && trip.RequestedAt.Equal(customer.RequestedAt) {
+			return trip, i
+		}
+	}
+	return nil, -1
+}
+
+func (t tripTracker) assigned(driver string, customer Customer, eta float64, now time.Time) {
+	t[driver] = append(t[driver], &Trip{
+		CustomerID:   customer.Id,
+		CustomerName: customer.Name,
+		Driver:       driver,
+		RequestedAt:  customer.RequestedAt,
+		AssignedAt:   now,
+		PredictedETA: eta,
+	})
+}
+
+func (t tripTracker) pickedUp(driver string, customer Customer, now time.Time) {
+	trip, _ := t.find(driver, customer)
+	if trip == nil {
+		return
+	}
+	trip.PickedUpAt = now
+	trip.ActualETA = now.Sub(trip.AssignedAt).Minutes()
+	trip.Route = []LatLon{{Lat: customer.Lat, Lon: customer.Lon}}
+}
+
+// moved charges one step of the driver's route to every customer on board.
+func (t tripTracker) moved(driver string, to GraphNode, meters, liters float64) {
+	for _, trip := range t[driver] {
+		if trip.PickedUpAt.IsZero() {
+			continue
+		}
+		if last := trip.Route[len(trip.Route)-1]; last.Lat != to.Lat || last.Lon != to.Lon {
+			trip.Route = append(trip.Route, LatLon{Lat: to.Lat, Lon: to.Lon})
+		}
+		trip.Distance += meters
+		trip.Fuel += liters
+	}
+}
+
+// cancelled forgets the trip of a customer who cancelled before pickup.
+func (t tripTracker) cancelled(driver string, customer Customer) {
+	if _, i := t.find(driver, customer); i >= 0 {
+		t[driver] = append(t[driver][:i], t[driver][i+1:]...)
+	}
+}
+
+// droppedOff closes the customer's trip and returns it for saving.
+func (t tripTracker) droppedOff(driver string, customer Customer, now time.Time) (Trip, bool) {
+	trip, i := t.find(driver, customer)
+	if trip == nil {
+		return Trip{}, false
+	}
+	t[driver] = append(t[driver][:i], t[driver][i+1:]...)
+	trip.DroppedOffAt = now
+	return *trip, true
+}
+
+func (s *Simulation) saveTrip(trip Trip) {
+	if _, err := s.Trips.Save(trip); err != nil {
+		fmt.Printf("❌ Failed to save trip for %s: %v\n", trip.CustomerName, err)
+	}
+}
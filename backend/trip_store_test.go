@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tripStores opens one of each TripStore for a test.
+func tripStores(t *testing.T) map[string]TripStore {
+	t.Helper()
+	bolt, err := OpenBoltTripStore(filepath.Join(t.TempDir(), "trips.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	return map[string]TripStore{"memory": NewMemoryTripStore(), "bolt": bolt}
+}
+
+func TestTripStoresServeTrips(t *testing.T) {
+	at := func(minute int) time.Time { return testStart.Add(time.Duration(minute) * time.Minute) }
+	// Saved out of drop-off order, with two drop-offs at the same time
+	saved := []Trip{
+		{CustomerName: "Ann", Driver: "Ada", DroppedOffAt: at(10)},
+		{CustomerName: "Ben", Driver: "Bo", DroppedOffAt: at(5)},
+		{CustomerName: "Cat", Driver: "Ada", DroppedOffAt: at(20)},
+		{CustomerName: "Dan", Driver: "Bo", DroppedOffAt: at(30)},
+		{CustomerName: "Eve", Driver: "Ada", DroppedOffAt: at(30)},
+	}
+	stamp := func(minute int) string { return at(minute).Format(time.RFC3339) }
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Ben", "Ann", "Cat", "Dan", "Eve"}},
+		{"from=" + stamp(10) + "&to=" + stamp(30), []string{"Ann", "Cat"}},
+		{"from=" + stamp(30), []string{"Dan", "Eve"}},
+		{"to=" + stamp(10), []string{"Ben"}},
+		{"driver=Ada", []string{"Ann", "Cat", "Eve"}},
+		{"driver=Ada&from=" + stamp(15), []string{"Cat", "Eve"}},
+		{"driver=Cy", []string{}},
+		{"limit=2", []string{"Ben", "Ann"}},
+	}
+
+	for name, store := range tripStores(t) {
+		for i, trip := range saved {
+			got, err := store.Save(trip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != uint64(i+1) {
+				t.Errorf("%s: trip %d saved with ID %d", name, i+1, got.ID)
+			}
+		}
+		sim, err := NewSimulation("test", testGrid(2, 2), simDefaults, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			getTrips(sim, w, httptest.NewRequest(http.MethodGet, "/trips?"+tt.query, nil))
+			var trips []Trip
+			if err := json.Unmarshal(w.Body.Bytes(), &trips); w.Code != http.StatusOK || err != nil {
+				t.Fatalf("%s: /trips?%s: %d %s", name, tt.query, w.Code, w.Body.String())
+			}
+			got := make([]string, len(trips))
+			for i, trip := range trips {
+				got[i] = trip.CustomerName
+			}
+			if !sameOrder(got, tt.want) {
+				t.Errorf("%s: /trips?%s gave %v, want %v", name, tt.query, got, tt.want)
+			}
+		}
+		w := httptest.NewRecorder()
+		getTrips(sim, w, httptest.NewRequest(http.MethodGet, "/trips?from=yesterday", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: a bad from gave %d", name, w.Code)
+		}
+	}
+}
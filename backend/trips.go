@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// getTrips serves completed trips. Optional query parameters: from and to
+// (RFC 3339, matched against the drop-off time), driver and limit.
+func getTrips(sim *Simulation, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseTripQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trips, err := sim.Trips.Query(q)
+	if err != nil {
+		log.Printf("❌ Failed to query trips: %v\n", err)
+		http.Error(w, "Failed to query trips", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(trips); err != nil {
+		log.Printf("❌ Failed to encode trips: %v\n", err)
+	}
+}
+
+func parseTripQuery(r *http.Request) (TripQuery, error) {
+	params := r.URL.Query()
+	q := TripQuery{Driver: params.Get("driver")}
+	var err error
+	if s := params.Get("from"); s != "" {
+		if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid from %q: want RFC 3339", s)
+		}
+	}
+	if s := params.Get("to"); s != "" {
+		if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid to %q: want RFC 3339", s)
+		}
+	}
+	if s := params.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	return q, nil
+}
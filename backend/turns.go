@@ -0,0 +1,183 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Turn rule kinds, as stored in RoadGraph.TurnKind.
+const (
+	turnBanned  = 1 // "no"
+	turnOnly    = 2 // "only"
+	turnPenalty = 3 // "penalty"
+)
+
+var turnKinds = map[string]uint8{"no": turnBanned, "only": turnOnly, "penalty": turnPenalty}
+
+// turnClass is which way a vehicle turns at a node.
+type turnClass int
+
+const (
+	turnStraight turnClass = iota
+	turnRight
+	turnLeft
+	turnU
+)
+
+// TurnCosts are the delays in seconds of turning at an intersection rather
+// than going straight on. Traffic keeps right, so a left turn waits for a gap
+// in oncoming traffic and costs more than a right.
+type TurnCosts struct {
+	Right float64
+	Left  float64
+	UTurn float64
+}
+
+var turnCosts = TurnCosts{Right: 2, Left: 6, UTurn: 20}
+
+// bearing is the direction in radians from one point to another,
+// counterclockwise from east, on a flat projection around the first.
+func bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	k := math.Cos(lat1 * math.Pi / 180)
+	return math.Atan2(lat2-lat1, (lon2-lon1)*k)
+}
+
+// classifyTurn names the turn from heading in to heading out. back is set
+// when out leads straight back to where in came from.
+func classifyTurn(in, out float64, back bool) turnClass {
+	angle := (out - in) * 180 / math.Pi // left is positive
+	if angle > 180 {
+		angle -= 360
+	} else if angle < -180 {
+		angle += 360
+	}
+	switch {
+	case back || math.Abs(angle) > 150:
+		return turnU
+	case angle > 30:
+		return turnLeft
+	case angle < -30:
+		return turnRight
+	}
+	return turnStraight
+}
+
+// prepareTurns derives what pricing turns needs from the edge arrays: each
+// edge's source and heading, and which nodes are intersections.
+func (g *RoadGraph) prepareTurns() {
+	n, m := g.NumNodes(), len(g.EdgeTo)
+	g.EdgeSource = make([]int32, m)
+	g.EdgeBearing = make([]float64, m)
+	degree := make([]int, n) // distinct neighbors, in or out
+	for i := int32(0); i < int32(n); i++ {
+		lo, hi := g.Edges(i)
+		degree[i] += int(hi - lo)
+		for e := lo; e < hi; e++ {
+			to := g.EdgeTo[e]
+			g.EdgeSource[e] = i
+			g.EdgeBearing[e] = bearing(g.Lat.At(i), g.Lon.At(i), g.Lat.At(to), g.Lon.At(to))
+			if _, ok := g.FindEdge(to, i); !ok {
+				degree[to]++ // a one-way road in
+			}
+		}
+	}
+	// Most nodes only shape a bending road; turning there is free
+	g.Junction = make([]bool, n)
+	for i, d := range degree {
+		g.Junction[i] = d > 2
+	}
+}
+
+// turnDelay is the usual delay in seconds of leaving over edge out after
+// arriving over edge in. Turning round costs the same anywhere; other turns
+// only at intersections.
+func (g *RoadGraph) turnDelay(in, out int32) float64 {
+	back := g.EdgeTo[out] == g.EdgeSource[in]
+	if !back && !g.Junction[g.EdgeTo[in]] {
+		return 0
+	}
+	switch classifyTurn(g.EdgeBearing[in], g.EdgeBearing[out], back) {
+	case turnRight:
+		return turnCosts.Right
+	case turnLeft:
+		return turnCosts.Left
+	case turnU:
+		return turnCosts.UTurn
+	}
+	return 0
+}
+
+// Turn reports whether a vehicle arriving over edge in may leave over edge
+// out, which must leave the node in arrives at, and how many seconds the
+// turn takes.
+func (g *RoadGraph) Turn(in, out int32) (float64, bool) {
+	seconds := g.turnDelay(in, out)
+	k := sort.Search(len(g.TurnIn), func(i int) bool { return g.TurnIn[i] >= in })
+	only, allowed := false, false
+	for ; k < len(g.TurnIn) && g.TurnIn[k] == in; k++ {
+		match := g.TurnOut[k] == out
+		switch g.TurnKind[k] {
+		case turnBanned:
+			if match {
+				return 0, false
+			}
+		case turnOnly:
+			only = true
+			allowed = allowed || match
+		case turnPenalty:
+			if match {
+				seconds = g.TurnSeconds[k]
+			}
+		}
+	}
+	if only && !allowed {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// addTurnRules turns the rules in nodes into edge pairs, sorted by the edge
+// they apply to, dropping rules whose roads aren't in the graph and ones that
+// don't make sense.
+func (g *RoadGraph) addTurnRules(nodes map[string]GraphNode, index map[string]int32) {
+	type rule struct {
+		in, out int32
+		kind    uint8
+		seconds float64
+	}
+	var rules []rule
+	for key, node := range nodes {
+		via, ok := index[key]
+		if !ok {
+			continue
+		}
+		for _, r := range node.Turns {
+			from, okFrom := index[r.From]
+			to, okTo := index[r.To]
+			kind, okKind := turnKinds[r.Kind]
+			if !okFrom || !okTo || !okKind || r.Seconds < 0 {
+				g.Report.DroppedTurns++
+				continue
+			}
+			in, okIn := g.FindEdge(from, via)
+			out, okOut := g.FindEdge(via, to)
+			if !okIn || !okOut {
+				g.Report.DroppedTurns++
+				continue
+			}
+			rules = append(rules, rule{in, out, kind, r.Seconds})
+		}
+	}
+	sort.Slice(rules, func(a, b int) bool {
+		if rules[a].in != rules[b].in {
+			return rules[a].in < rules[b].in
+		}
+		return rules[a].out < rules[b].out
+	})
+	for _, r := range rules {
+		g.TurnIn = append(g.TurnIn, r.in)
+		g.TurnOut = append(g.TurnOut, r.out)
+		g.TurnKind = append(g.TurnKind, r.kind)
+		g.TurnSeconds = append(g.TurnSeconds, r.seconds)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// turnGrid is a 3×3 grid with rules at the middle node 5. Arriving there
+// from 4 heads east; 6 is straight on, 8 north is a left and 2 south a right.
+func turnGrid(rules ...TurnRule) *RoadGraph {
+	nodes := testGridNodes(3, 3)
+	middle := nodes["5"]
+	middle.Turns = rules
+	nodes["5"] = middle
+	return buildRoadGraph(nodes)
+}
+
+func TestTurn(t *testing.T) {
+	tests := []struct {
+		name          string
+		rules         []TurnRule
+		from, via, to int
+		want          float64 // seconds; -1 when the turn isn't allowed
+	}{
+		{"straight", nil, 4, 5, 6, 0},
+		{"right", nil, 4, 5, 2, turnCosts.Right},
+		{"left", nil, 4, 5, 8, turnCosts.Left},
+		{"U-turn", nil, 4, 5, 4, turnCosts.UTurn},
+		{"right off a junction", nil, 2, 1, 4, 0},
+		{"U-turn off a junction", nil, 2, 1, 2, turnCosts.UTurn},
+		{"penalty", []TurnRule{{From: "4", To: "8", Kind: "penalty", Seconds: 30}}, 4, 5, 8, 30},
+		{"penalty elsewhere", []TurnRule{{From: "4", To: "8", Kind: "penalty", Seconds: 30}}, 4, 5, 2, turnCosts.Right},
+		{"banned", []TurnRule{{From: "4", To: "8", Kind: "no"}}, 4, 5, 8, -1},
+		{"banned elsewhere", []TurnRule{{From: "4", To: "8", Kind: "no"}}, 6, 5, 8, turnCosts.Right},
+		{"only, the allowed exit", []TurnRule{{From: "4", To: "6", Kind: "only"}}, 4, 5, 6, 0},
+		{"only, a right", []TurnRule{{From: "4", To: "6", Kind: "only"}}, 4, 5, 2, -1},
+		{"only, a left", []TurnRule{{From: "4", To: "6", Kind: "only"}}, 4, 5, 8, -1},
+		{"only, a U-turn", []TurnRule{{From: "4", To: "6", Kind: "only"}}, 4, 5, 4, -1},
+		{"only, another way in", []TurnRule{{From: "4", To: "6", Kind: "only"}}, 2, 5, 4, turnCosts.Left},
+	}
+	for _, tt := range tests {
+		g := turnGrid(tt.rules...)
+		in, okIn := g.findEdgeByID(tt.from, tt.via)
+		out, okOut := g.findEdgeByID(tt.via, tt.to)
+		if !okIn || !okOut {
+			t.Fatalf("%s: no road %d -> %d -> %d", tt.name, tt.from, tt.via, tt.to)
+		}
+		seconds, ok := g.Turn(in, out)
+		if tt.want < 0 {
+			if ok {
+				t.Errorf("%s: Turn allowed %d -> %d -> %d", tt.name, tt.from, tt.via, tt.to)
+			}
+			continue
+		}
+		if !ok || seconds != tt.want {
+			t.Errorf("%s: Turn = %v, %v, want %v, true", tt.name, seconds, ok, tt.want)
+			continue
+		}
+		// The route cost is the two roads plus the turn
+		path := []GraphNode{g.Node(g.EdgeSource[in]), g.Node(g.EdgeTo[in]), g.Node(g.EdgeTo[out])}
+		roads := routeCost.EdgeCost(g, in) + routeCost.EdgeCost(g, out)
+		if got := pathCostUnder(t, g, routeCost, path); math.Abs(got-roads-tt.want) > 1e-9 {
+			t.Errorf("%s: route costs %v, want %v for the roads and %v for the turn", tt.name, got, roads, tt.want)
+		}
+	}
+}
+
+func TestRoutesObeyTurnRules(t *testing.T) {
+	defer func(g *RoadGraph) { roadGraph = g }(roadGraph)
+	passes := func(path []GraphNode, id int) bool {
+		for _, n := range path {
+			if n.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 4 -> 2 is quickest turning right at 5
+	roadGraph = turnGrid()
+	if path := aStarGraph("4", "2"); !passes(path, 5) {
+		t.Fatalf("without turn rules 4 -> 2 goes %v, not through 5", nodeIDs(path))
+	}
+
+	tests := []struct {
+		name string
+		rule TurnRule
+	}{
+		{"banned", TurnRule{From: "4", To: "2", Kind: "no"}},
+		{"only straight on", TurnRule{From: "4", To: "6", Kind: "only"}},
+	}
+	for _, tt := range tests {
+		roadGraph = turnGrid(tt.rule)
+		path := aStarGraph("4", "2")
+		if path == nil {
+			t.Fatalf("%s: no route 4 -> 2", tt.name)
+		}
+		for i := 2; i < len(path); i++ {
+			if path[i-2].ID == 4 && path[i-1].ID == 5 && path[i].ID != 6 {
+				t.Errorf("%s: route %v turns off 4 -> 5 -> 6", tt.name, nodeIDs(path))
+			}
+		}
+		start, _ := roadGraph.Lookup("4")
+		goal, _ := roadGraph.Lookup("2")
+		want := nodeCosts(roadGraph, routeCost, start)[goal]
+		if got := pathCostUnder(t, roadGraph, routeCost, path); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: route %v costs %v, the cheapest %v", tt.name, nodeIDs(path), got, want)
+		}
+	}
+}
+
+func nodeIDs(path []GraphNode) []string {
+	ids := make([]string, len(path))
+	for i, n := range path {
+		ids[i] = strconv.Itoa(n.ID)
+	}
+	return ids
+}
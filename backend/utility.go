@@ -3,22 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"math/rand"
 	"net/http"
 	"os"
-	"strconv"
 )
 
-func reconstructPath(end *PathNode) []GraphNode {
-	var path []GraphNode
-	for node := end; node != nil; node = node.Parent {
-		path = append([]GraphNode{graph[strconv.Itoa(node.ID)]}, path...)
-	}
-	return path
-}
-
 func getGraphPath(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Tried to get path")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -46,15 +38,37 @@ func getGraphPath(w http.ResponseWriter, r *http.Request) {
 func loadGraph(filename string) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("Failed to open graph file: %v", err)
+		log.Fatalf("Failed to open graph file: %v (build one from OpenStreetMap with `ridesync import-osm`)", err)
 	}
 	defer file.Close()
-	graph = make(map[string]GraphNode)
 
-	if err := json.NewDecoder(file).Decode(&graph); err != nil {
+	// Binary graphs (see convert-graph) are mapped rather than decoded
+	magic := make([]byte, len(binaryGraphMagic))
+	if _, err := io.ReadFull(file, magic); err == nil && isBinaryGraph(magic) {
+		data, err := mapFile(file)
+		if err != nil {
+			log.Fatalf("Failed to map graph file: %v", err)
+		}
+		if roadGraph, err = readBinaryGraph(data); err != nil {
+			log.Fatalf("Rejected graph %s: %v", filename, err)
+		}
+		log.Printf("Successfully loaded binary graph with %d nodes\n", roadGraph.NumNodes())
+		roadGraph.Report.log()
+		loadContraction(roadGraph, filename)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		log.Fatalf("Failed to read graph file: %v", err)
+	}
+
+	var nodes map[string]GraphNode
+	if err := json.NewDecoder(file).Decode(&nodes); err != nil {
 		log.Fatalf("Failed to load graph: %v", err)
 	}
-	log.Printf("Successfully loaded graph with %d nodes\n", len(graph))
+	roadGraph = buildRoadGraph(nodes)
+	log.Printf("Successfully loaded graph with %d nodes\n", len(nodes))
+	roadGraph.Report.log()
+	loadContraction(roadGraph, filename)
 }
 
 func haversine(lat1, lon1, lat2, lon2 float64) float64 {
@@ -75,37 +89,62 @@ func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 }
 
 func findNearestNode(lat, lon float64) string {
-	var nearestID string
-	minDist := math.MaxFloat64
-
-	for id, node := range graph {
-		if len(node.Neighbors) < 2 { // avoid dead ends
-			continue
-		}
-		d := haversine(lat, lon, node.Lat, node.Lon)
-		if d < minDist {
-			minDist = d
-			nearestID = id
-		}
+	node, ok := roadGraph.Spatial.Nearest(lat, lon, snapOptions)
+	if !ok {
+		return ""
 	}
-	return nearestID
+	return roadGraph.Key(node)
 }
 
-func getRandomNodeID() string {
-	connected := []string{}
-	for id, node := range graph {
-		if len(node.Neighbors) > 0 {
-			connected = append(connected, id)
-		}
-	}
+func getRandomNodeID(g *RoadGraph, rng *rand.Rand) string {
+	connected := g.Spawnable
 	if len(connected) == 0 {
 		return "" // no valid nodes
 	}
-	return connected[rand.Intn(len(connected))]
+	return g.Key(connected[rng.Intn(len(connected))])
+}
+
+// getRandomNode is getRandomNodeID's node, or the zero node if there is none.
+func getRandomNode(g *RoadGraph, rng *rand.Rand) GraphNode {
+	node, ok := g.Lookup(getRandomNodeID(g, rng))
+	if !ok {
+		return GraphNode{}
+	}
+	return g.Node(node)
+}
+
+// aStarGraphCoords routes between two arbitrary points by snapping each onto
+// the nearest road edge, so the path can start and end mid-block.
+func aStarGraphCoords(g *RoadGraph, startLat, startLon, endLat, endLon float64) []GraphNode {
+	src, ok := g.Spatial.NearestEdge(startLat, startLon, snapOptions)
+	if !ok {
+		return nil
+	}
+	dst, ok := g.Spatial.NearestEdge(endLat, endLon, snapOptions)
+	if !ok {
+		return nil
+	}
+	return routeBetween(g, routeCost, src, dst)
+}
+
+// getRandomRoadPoint picks a random spot part-way along a road leaving a
+// random connected node.
+func getRandomRoadPoint(g *RoadGraph, rng *rand.Rand) (EdgeSnap, bool) {
+	node, ok := g.Lookup(getRandomNodeID(g, rng))
+	if !ok {
+		return EdgeSnap{Edge: -1}, false
+	}
+	return roadPointFrom(g, node, rng), true
 }
 
-func aStarGraphCoords(startLat, startLon, endLat, endLon float64) []GraphNode {
-	startID := findNearestNode(startLat, startLon)
-	endID := findNearestNode(endLat, endLon)
-	return aStarGraph(startID, endID)
+// roadPointFrom picks a random spot part-way along a random road leaving
+// node, which must have at least one.
+func roadPointFrom(g *RoadGraph, node int32, rng *rand.Rand) EdgeSnap {
+	lo, hi := g.Edges(node)
+	e := lo + int32(rng.Intn(int(hi-lo)))
+	to := g.EdgeTo[e]
+	t := rng.Float64()
+	lat := g.Lat.At(node) + t*(g.Lat.At(to)-g.Lat.At(node))
+	lon := g.Lon.At(node) + t*(g.Lon.At(to)-g.Lon.At(node))
+	return g.snapToEdge(e, lat, lon)
 }
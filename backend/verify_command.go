@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// runVerifyCommand implements `ridesync verify`: it runs a recorded sim run
+// again and checks that it publishes exactly the events in the log, so a
+// code change that alters behaviour is caught with the event it first
+// changed.
+func runVerifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	logPath := fs.String("log", "", "event log written by `ridesync sim -event-log`")
+	graphPath := fs.String("graph", "", "road graph to run on; empty uses the one the log was recorded with")
+	fs.Parse(args)
+
+	if *logPath == "" {
+		return fmt.Errorf("-log is required")
+	}
+	segments, err := readEventLog(*logPath)
+	if err != nil {
+		return err
+	}
+	seg := segments[0]
+	if seg.Header.Source != "sim" {
+		return fmt.Errorf("%s was recorded by the %s; only `ridesync sim` runs are repeatable", *logPath, seg.Header.Source)
+	}
+	if len(segments) > 1 {
+		return fmt.Errorf("%s holds %d runs; verify checks a log with one", *logPath, len(segments))
+	}
+
+	run := seg.Header.Run
+	if *graphPath != "" {
+		run.Graph = *graphPath
+	}
+	loadGraph(run.Graph)
+	if roadGraph.NumNodes() != seg.Header.GraphNodes || len(roadGraph.EdgeTo) != seg.Header.GraphEdges {
+		return fmt.Errorf("graph %s has %d nodes and %d edges, the log was recorded on %d and %d",
+			run.Graph, roadGraph.NumNodes(), len(roadGraph.EdgeTo), seg.Header.GraphNodes, seg.Header.GraphEdges)
+	}
+
+	v := &logVerifier{events: seg.Events}
+	if _, err := run.execute(roadGraph, nil, v); err != nil {
+		return err
+	}
+	if v.divergence == "" && v.next < len(v.events) {
+		want := v.events[v.next]
+		v.divergence = fmt.Sprintf("the run ended after %d events; the log goes on from event %d (line %d), %s",
+			v.next, want.Seq, want.Line, describeRecorded(want))
+	}
+	if v.divergence != "" {
+		return fmt.Errorf("❌ %s diverged: %s", *logPath, v.divergence)
+	}
+	fmt.Printf("✅ All %d events in %s match\n", len(v.events), *logPath)
+	return nil
+}
+
+// logVerifier compares the events a run publishes with a log's, and keeps
+// the first difference.
+type logVerifier struct {
+	events     []RecordedEvent
+	next       int
+	divergence string
+}
+
+func (v *logVerifier) Record(ev LiveEvent) {
+	if v.divergence != "" {
+		return
+	}
+	if v.next == len(v.events) {
+		v.divergence = fmt.Sprintf("the log ends after %d events, the run went on with %s",
+			len(v.events), describeRecorded(RecordedEvent{Type: ev.Type, Time: ev.Time, Driver: ev.Driver}))
+		return
+	}
+	want := v.events[v.next]
+	v.next++
+
+	recorded, err := comparable(LiveEvent{Type: want.Type, Time: want.Time, Driver: want.Driver, Data: want.Data})
+	if err == nil {
+		var got any
+		if got, err = comparable(logForm(ev)); err == nil {
+			if diff := firstDifference(recorded, got, ""); diff != "" {
+				v.divergence = fmt.Sprintf("event %d (line %d), %s: %s", want.Seq, want.Line, describeRecorded(want), diff)
+			}
+			return
+		}
+	}
+	v.divergence = fmt.Sprintf("event %d (line %d): %v", want.Seq, want.Line, err)
+}
+
+// comparable puts an event in the form it takes in a log, so a recorded and
+// a fresh event compare field by field.
+func comparable(ev LiveEvent) (any, error) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+func describeRecorded(e RecordedEvent) string {
+	s := e.Type
+	if e.Driver != "" {
+		s += " for " + e.Driver
+	}
+	return s + " at " + e.Time.Format(time.RFC3339Nano)
+}
+
+// firstDifference returns where want and got first differ, as a field path
+// and both values, or "" if they are the same.
+func firstDifference(want, got any, path string) string {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			field := k
+			if path != "" {
+				field = path + "." + k
+			}
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case !inWant:
+				return fmt.Sprintf("%s: not recorded, got %s", field, brief(gv))
+			case !inGot:
+				return fmt.Sprintf("%s: recorded %s, got nothing", field, brief(wv))
+			}
+			if diff := firstDifference(wv, gv, field); diff != "" {
+				return diff
+			}
+		}
+		return ""
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(w) && i < len(g); i++ {
+			if diff := firstDifference(w[i], g[i], path+"["+strconv.Itoa(i)+"]"); diff != "" {
+				return diff
+			}
+		}
+		if len(w) != len(g) {
+			return fmt.Sprintf("%s: recorded %d entries, got %d", path, len(w), len(g))
+		}
+		return ""
+	default:
+		if want == got {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%s: recorded %s, got %s", path, brief(want), brief(got))
+}
+
+// brief renders v as JSON, cut short if it is long.
+func brief(v any) string {
+	data, _ := json.Marshal(v)
+	if len(data) > 80 {
+		return string(data[:77]) + "..."
+	}
+	return string(data)
+}
@@ -1,8 +1,7 @@
 
 
 
-import {getCustomer} from "./utility.js";
-import {getPairing} from "./utility.js";
+import {getCustomer, subscribeLiveState} from "./utility.js";
 
 
 async function main(){
@@ -70,6 +69,7 @@ async function main(){
 
   const custData = await custRes.json();
   const custque = custData.custque || [];
+  const live = subscribeLiveState();
     
 
 
@@ -183,7 +183,6 @@ function renderDriverPanel(drivers) {
 
     
     
-    let currentCustomer = {};
  
     
   // 1. SET GRID FIRST
@@ -205,8 +204,7 @@ function renderDriverPanel(drivers) {
   }
   setInterval(async () => {
 
-    const res = await fetch("/get-drivers");
-    const drivers = await res.json();
+    const drivers = Object.values(live.drivers);
     const activeQueueIds = new Set();
     const queueList = document.getElementById("queue-list");
     
@@ -383,43 +381,13 @@ function renderDriverPanel(drivers) {
 }, 1000);
 
 
-setInterval(async () => {
-  const custRes = await fetch("/get-cust-que", {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    body: JSON.stringify({})
-  });
-
-  const custData = await custRes.json();
-  const custque = custData.custque || [];
+setInterval(() => {
+  const custque = live.custque;
   const queueList = document.getElementById("queue-list");
   if (!queueList) return;
   renderCustomerPanel(custque)
 }, 200);
 
-  document.getElementById("acceptcustomer").addEventListener("click", handleNodeAccept);
-    async function handleNodeAccept(){
-      let idealDriver;
-      const res = await fetch("/get-drivers");
-      const drivers = await res.json();
-      let pairing = await getPairing(drivers)
-      currentCustomer = pairing.currentCustomer
-     
-      idealDriver = drivers[pairing.idealDriver]
-      console.log(currentCustomer)
-      await fetch("/assign-customer", {
-        method: "POST",
-        body: JSON.stringify({customer: currentCustomer,  driverName: idealDriver.name }),
-        headers: { "Content-Type": "application/json" }
-      }).then(response=>response.json())
-      .then(data=> {
-        console.log(data)
-        return data
-      })
-  
-
-      
-   }
   document.getElementById("customerping").addEventListener("click", handleNodeSpawn);
   async function handleNodeSpawn(){
 
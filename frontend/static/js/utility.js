@@ -45,4 +45,34 @@ export async function getPairing(drivers){
       console.log(data)
       return data
     })
+}
+
+// Keeps a live copy of the drivers and customer queue from the /events
+// stream. EventSource reconnects on its own, and every connection starts
+// with a fresh snapshot.
+export function subscribeLiveState(){
+  const state = { drivers: {}, custque: [] }
+  const source = new EventSource('/events')
+  const on = (type, apply) => source.addEventListener(type, e => apply(JSON.parse(e.data)))
+
+  on('snapshot', ev => {
+    state.drivers = {}
+    ev.data.drivers.forEach(d => { state.drivers[d.name] = d })
+    state.custque = ev.data.custque || []
+  })
+  on('routeChanged', ev => { state.drivers[ev.driver] = ev.data })
+  on('driverMoved', ev => { Object.assign(state.drivers[ev.driver] || {}, ev.data) })
+  on('etaChanged', ev => {
+    if (state.drivers[ev.driver]) state.drivers[ev.driver].eta = ev.data
+  })
+  on('customerEnqueued', ev => {
+    if (!state.custque.some(c => c.id === ev.data.id)) state.custque.push(ev.data)
+  })
+  const dequeue = ev => {
+    state.custque = state.custque.filter(c => c.id !== ev.data.id)
+  }
+  on('customerAssigned', dequeue)
+  on('customerCancelled', dequeue)
+  on('customerExpired', dequeue)
+  return state
 }
\ No newline at end of file
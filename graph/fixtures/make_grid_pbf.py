@@ -0,0 +1,140 @@
+"""Writes grid.osm.pbf from grid.osm, for testing `ridesync import-osm` on PBF.
+
+    python3 graph/fixtures/make_grid_pbf.py
+
+The encoding is done by hand so the fixture doesn't depend on the reader it
+tests. Besides what osmium writes (a zlib-compressed block of dense nodes,
+ways and relations with packed fields) it stores the header blob
+uncompressed, node 10 as a plain node, and way 104's refs unpacked, all of
+which the format allows.
+"""
+import os
+import struct
+import xml.etree.ElementTree as ET
+import zlib
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+
+def varint(v):
+    out = bytearray()
+    while True:
+        b = v & 0x7F
+        v >>= 7
+        if v:
+            out.append(b | 0x80)
+        else:
+            out.append(b)
+            return bytes(out)
+
+
+def zigzag(v):
+    return (v << 1) ^ (v >> 63)
+
+
+def key(field, wire):
+    return varint(field << 3 | wire)
+
+
+def field_varint(field, v):
+    return key(field, 0) + varint(v)
+
+
+def field_bytes(field, data):
+    if isinstance(data, str):
+        data = data.encode()
+    return key(field, 2) + varint(len(data)) + data
+
+
+def packed(field, values):
+    return field_bytes(field, b"".join(varint(v) for v in values))
+
+
+def unpacked(field, values):
+    return b"".join(field_varint(field, v) for v in values)
+
+
+def deltas(values):
+    out, prev = [], 0
+    for v in values:
+        out.append(zigzag(v - prev))
+        prev = v
+    return out
+
+
+def blob(kind, data, compress):
+    if compress:
+        body = field_varint(2, len(data)) + field_bytes(3, zlib.compress(data))
+    else:
+        body = field_bytes(1, data)
+    header = field_bytes(1, kind) + field_varint(3, len(body))
+    return struct.pack(">I", len(header)) + header + body
+
+
+def main():
+    root = ET.parse(os.path.join(HERE, "grid.osm")).getroot()
+    strings = [""]
+
+    def sid(s):
+        if s not in strings:
+            strings.append(s)
+        return strings.index(s)
+
+    def tags(el):
+        return [(sid(t.get("k")), sid(t.get("v"))) for t in el.findall("tag")]
+
+    def coord(v):
+        return round(float(v) * 1e7)  # granularity 100 nanodegrees
+
+    nodes = root.findall("node")
+    dense, plain = [n for n in nodes if n.get("id") != "10"], [n for n in nodes if n.get("id") == "10"]
+
+    ids = [int(n.get("id")) for n in dense]
+    keys_vals = []
+    for n in dense:
+        for k, v in tags(n):
+            keys_vals += [k, v]
+        keys_vals.append(0)
+    dense_msg = (packed(1, deltas(ids)) + packed(8, deltas([coord(n.get("lat")) for n in dense]))
+                 + packed(9, deltas([coord(n.get("lon")) for n in dense])) + packed(10, keys_vals))
+    groups = [field_bytes(2, dense_msg)]
+
+    node_group = b""
+    for n in plain:
+        t = tags(n)
+        msg = (field_varint(1, zigzag(int(n.get("id")))) + unpacked(2, [k for k, _ in t]) + unpacked(3, [v for _, v in t])
+               + field_varint(8, zigzag(coord(n.get("lat")))) + field_varint(9, zigzag(coord(n.get("lon")))))
+        node_group += field_bytes(1, msg)
+    groups.append(node_group)
+
+    way_group = b""
+    for w in root.findall("way"):
+        t = tags(w)
+        refs = deltas([int(nd.get("ref")) for nd in w.findall("nd")])
+        msg = field_varint(1, int(w.get("id"))) + packed(2, [k for k, _ in t]) + packed(3, [v for _, v in t])
+        msg += unpacked(8, refs) if w.get("id") == "104" else packed(8, refs)
+        way_group += field_bytes(3, msg)
+    groups.append(way_group)
+
+    types = {"node": 0, "way": 1, "relation": 2}
+    relation_group = b""
+    for r in root.findall("relation"):
+        t = tags(r)
+        members = r.findall("member")
+        msg = (field_varint(1, int(r.get("id"))) + packed(2, [k for k, _ in t]) + packed(3, [v for _, v in t])
+               + packed(8, [sid(m.get("role")) for m in members]) + packed(9, deltas([int(m.get("ref")) for m in members]))
+               + packed(10, [types[m.get("type")] for m in members]))
+        relation_group += field_bytes(4, msg)
+    groups.append(relation_group)
+
+    table = b"".join(field_bytes(1, s) for s in strings)
+    block = field_bytes(1, table) + b"".join(field_bytes(2, g) for g in groups)
+    header = field_bytes(4, "OsmSchema-V0.6") + field_bytes(4, "DenseNodes") + field_bytes(16, "make_grid_pbf.py")
+
+    with open(os.path.join(HERE, "grid.osm.pbf"), "wb") as f:
+        f.write(blob("OSMHeader", header, compress=False))
+        f.write(blob("OSMData", block, compress=True))
+
+
+if __name__ == "__main__":
+    main()